@@ -4,12 +4,17 @@ import (
   "net/http"
   "encoding/json"
   "strconv"
+  "strings"
   "time"
   "errors"
+  "io"
+  "os"
+  "path/filepath"
   "github.com/Sirupsen/logrus"
   "github.com/gorilla/mux"
   "github.com/brysearl/omicrond/job"
   "github.com/brysearl/omicrond/conf"
+  "github.com/brysearl/omicrond/metrics"
   "github.com/goji/httpauth"
 )
 //"github.com/davecgh/go-spew/spew"
@@ -18,18 +23,24 @@ type ChanComm struct {
   Signal          string
   RunningSchedule job.JobSchedule
   RunningJobs     job.RunningJobTracker
+  RunJob          job.RunningJob
+  Token           string
   Error           error
 }
 
 var runningChanComm chan ChanComm
 
 // StartServer - Create a TCP server running on the address and port configured in conf.go or cli arg.
-//  Should be run in a goroutine
+//  The same routes are served on the unix socket if enabled.  Should be run in a goroutine
 func StartServer(commChannel chan ChanComm) {
 
   runningChanComm = commChannel
   router := buildRoutes(mux.NewRouter())
 
+  if conf.Attr.APISocket == true {
+    go startSocketServer(router)
+  }
+
   logrus.Info("Starting HTTP interface")
   srv := &http.Server{
     Handler:      httpauth.SimpleBasicAuth(conf.Attr.APIUser, conf.Attr.APIPassword)(router),
@@ -56,10 +67,16 @@ func buildRoutes(router *mux.Router) *mux.Router {
   router.HandleFunc("/schedule/edit/job/{jobLabel:[a-zA-Z0-9_]+}", scheduleEditJob).Methods("POST")
   router.HandleFunc("/schedule/create/job", scheduleCreateJob).Methods("POST")
   router.HandleFunc("/schedule/delete/job/{jobLabel:[a-zA-Z0-9_]+}", scheduleDeleteJob).Methods("POST")
+  router.HandleFunc("/schedule/run/job/{jobLabel:[a-zA-Z0-9_]+}", scheduleRunJob).Methods("POST")
+  router.HandleFunc("/schedule/test/job/{jobLabel:[a-zA-Z0-9_]+}", scheduleTestJob).Methods("POST")
   router.HandleFunc("/runningjob/get/list", runningjobGetList).Methods("GET")
   router.HandleFunc("/runningjob/get/token/{jobToken:[a-zA-Z0-9]+}", runningjobGetToken).Methods("GET")
   router.HandleFunc("/runningjob/stop/token/{jobToken:[a-zA-Z0-9]+}", runningjobStopToken).Methods("GET")
   router.HandleFunc("/runningjob/tail/token/{jobToken:[a-zA-Z0-9]+}", runningjobTailToken).Methods("GET")
+  router.HandleFunc("/history/get/job/{jobLabel:[a-zA-Z0-9_]+}", historyGetJob).Methods("GET")
+  router.HandleFunc("/history/get/token/{jobToken:[a-zA-Z0-9]+}", historyGetToken).Methods("GET")
+  router.HandleFunc("/history/output/token/{jobToken:[a-zA-Z0-9]+}", historyOutputToken).Methods("GET")
+  router.HandleFunc("/metrics", getMetrics).Methods("GET")
 
   return router
 }
@@ -72,6 +89,19 @@ func getStatus(w http.ResponseWriter, r *http.Request) {
   return
 }
 
+// getMetrics - Send the daemon's scheduling and job metrics in the Prometheus text format
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+  logrus.Debug("API request for metrics")
+
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+  err := metrics.WriteText(w)
+  if err != nil {
+    logrus.Debug("Could not send metrics: " + err.Error())
+  }
+
+  return
+}
+
 // scheduleGetList - Send a JSON representation of the JobSchedule object within job.go
 func scheduleGetList(w http.ResponseWriter, r *http.Request) {
   logrus.Debug("API request for Omicrond job list")
@@ -160,12 +190,17 @@ func scheduleEditJob(w http.ResponseWriter, r *http.Request) {
     return
   }
 
-  // Put the job back into the schedule
-  newSchedule := currentSchedule
+  // Only let callers create jobs that run as users they are allowed to use
+  err = checkRunAsAllowed(apiCaller(r), requestedJob)
   if err != nil {
-    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusForbidden)
     return
   }
+
+  // Put the job back into a copy of the schedule.  The running schedule shares its Job slice with us and has to stay
+  //  untouched if the change is rejected
+  newSchedule := currentSchedule
+  newSchedule.Job = append([]job.JobConfig(nil), currentSchedule.Job...)
   newSchedule.Job[jobIndex] = requestedJob
 
   // Make sure the changes are okay
@@ -198,8 +233,16 @@ func scheduleCreateJob(w http.ResponseWriter, r *http.Request) {
     return
   }
 
-  // Put the new job into the schedule
+  // Only let callers create jobs that run as users they are allowed to use
+  err = checkRunAsAllowed(apiCaller(r), newJob)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusForbidden)
+    return
+  }
+
+  // Put the new job into a copy of the schedule
   newSchedule := currentSchedule
+  newSchedule.Job = append([]job.JobConfig(nil), currentSchedule.Job...)
   newSchedule.Job = append(newSchedule.Job, newJob)
 
   // Make sure the changes are okay
@@ -234,9 +277,10 @@ func scheduleDeleteJob(w http.ResponseWriter, r *http.Request) {
     return
   }
 
-  // Put the job back into the schedule
+  // Take the job out of a copy of the schedule
   newSchedule := currentSchedule
-  newSchedule.Job = append(newSchedule.Job[:jobIndex], newSchedule.Job[jobIndex + 1:]...)
+  newSchedule.Job = append([]job.JobConfig(nil), currentSchedule.Job[:jobIndex]...)
+  newSchedule.Job = append(newSchedule.Job, currentSchedule.Job[jobIndex + 1:]...)
 
   // Make sure the changes are okay
   err = newSchedule.CheckConfig()
@@ -251,6 +295,138 @@ func scheduleDeleteJob(w http.ResponseWriter, r *http.Request) {
   return
 }
 
+// scheduleRunJob - Start a configured job immediately, outside of its schedule.  Concurrency limits are respected and
+//  runs queued behind one get their token straight away.
+//  Optional form fields: 'args' (repeatable) appended to the command and 'env' (repeatable, KEY=value)
+func scheduleRunJob(w http.ResponseWriter, r *http.Request) {
+  logrus.Debug("API request to run Omicrond job")
+
+  // Assign the JSON encoder
+  encoder := json.NewEncoder(w)
+
+  // Convert the route variables
+  vars := mux.Vars(r)
+  jobLabelStr := vars["jobLabel"]
+
+  // Request the current running schedule from the main scheduling loop
+  runningChanComm <- ChanComm{Signal: "scheduleGetList", RunningSchedule: job.JobSchedule{} }
+  returnComm := <-runningChanComm
+  currentSchedule := returnComm.RunningSchedule
+
+  // Retrieve the Job
+  requestedJob, _, err := currentSchedule.GetJobByLabel(jobLabelStr)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  // Arguments and environment change what runs, so only callers allowed to run as the job's user may start it
+  err = checkRunAsAllowed(apiCaller(r), requestedJob)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusForbidden)
+    return
+  }
+
+  extraArgs, extraEnv, err := parseFormFieldsIntoOverrides(r)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  // Hand the job to the main scheduling loop to be started and tracked
+  runningChanComm <- ChanComm{Signal: "runJob", RunJob: job.RunningJob{
+    Config: requestedJob,
+    Trigger: job.TRIGGERMANUAL,
+    ExtraArgs: extraArgs,
+    ExtraEnv: extraEnv}}
+  returnComm = <-runningChanComm
+  if returnComm.Error != nil {
+    http.Error(w, "{ \"Error\":\"" + returnComm.Error.Error() + "\"}", http.StatusConflict)
+    return
+  }
+
+  // Return the run token so the run can be followed
+  err = encoder.Encode(struct{ Token string }{Token: returnComm.Token})
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  return
+}
+
+// scheduleTestJob - Validate a configured job and run it once in a sandbox without tracking or recording it.
+//  Optional form fields: 'args', 'env' as with scheduleRunJob, 'execute=false' to only validate and 'timeout'
+//  in seconds (default 30)
+func scheduleTestJob(w http.ResponseWriter, r *http.Request) {
+  logrus.Debug("API request to test Omicrond job")
+
+  // Assign the JSON encoder
+  encoder := json.NewEncoder(w)
+
+  // Convert the route variables
+  vars := mux.Vars(r)
+  jobLabelStr := vars["jobLabel"]
+
+  // Request the current running schedule from the main scheduling loop
+  runningChanComm <- ChanComm{Signal: "scheduleGetList", RunningSchedule: job.JobSchedule{} }
+  returnComm := <-runningChanComm
+  currentSchedule := returnComm.RunningSchedule
+
+  // Retrieve the Job
+  requestedJob, _, err := currentSchedule.GetJobByLabel(jobLabelStr)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  // Arguments and environment change what runs, so only callers allowed to run as the job's user may start it
+  err = checkRunAsAllowed(apiCaller(r), requestedJob)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusForbidden)
+    return
+  }
+
+  extraArgs, extraEnv, err := parseFormFieldsIntoOverrides(r)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  execute := true
+  switch r.PostFormValue("execute") {
+  case "", "true":
+  case "false":
+    execute = false
+  default:
+    http.Error(w, "{ \"Error\":\"form field 'execute' must equal either 'true' or 'false'\"}", http.StatusBadRequest)
+    return
+  }
+
+  timeout := 30 * time.Second
+  if timeoutStr := r.PostFormValue("timeout"); timeoutStr != "" {
+    timeoutSeconds, err := strconv.Atoi(timeoutStr)
+    if err != nil || timeoutSeconds <= 0 {
+      http.Error(w, "{ \"Error\":\"form field 'timeout' must be a positive number of seconds\"}", http.StatusBadRequest)
+      return
+    }
+    timeout = time.Duration(timeoutSeconds) * time.Second
+  }
+
+  // The test can outlast the server's write timeout
+  http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+  // Failures of the job itself are part of the result rather than an API error
+  result, _ := requestedJob.DryRun(extraArgs, extraEnv, execute, timeout)
+  err = encoder.Encode(result)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  return
+}
+
 // runningjobGetList - Send a JSON representation of the currently running jobs
 func runningjobGetList(w http.ResponseWriter, r *http.Request) {
   logrus.Debug("API request for Omicrond running jobs")
@@ -309,6 +485,9 @@ func runningjobGetToken(w http.ResponseWriter, r *http.Request) {
 
   return
 }
+
+// runningjobStopToken - Stop a running job and everything it spawned.  Queued runs are dropped before they start.
+//  Query parameter 'signal' (TERM, INT, HUP or KILL) overrides the job's StopSignal
 func runningjobStopToken(w http.ResponseWriter, r *http.Request) {
   logrus.Debug("API request to stop job")
 
@@ -316,6 +495,15 @@ func runningjobStopToken(w http.ResponseWriter, r *http.Request) {
   vars := mux.Vars(r)
   jobToken := vars["jobToken"]
 
+  // Optional signal to send instead of the job's StopSignal
+  stopSignal := r.FormValue("signal")
+  if stopSignal != "" {
+    if _, err := job.ParseStopSignal(stopSignal); err != nil {
+      http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+      return
+    }
+  }
+
   // Request the current running schedule from the main scheduling loop
   runningJob, err := getRunningJobByToken(jobToken)
   if err != nil {
@@ -323,27 +511,39 @@ func runningjobStopToken(w http.ResponseWriter, r *http.Request) {
     return
   }
 
-  runningJob.Channel <- job.ChanComm{Signal:"stop process"}
+  // Runs that finish before the request reaches them have nothing left to stop
+  select {
+  case runningJob.Channel <- job.ChanComm{Signal:"stop process", StopSignal: stopSignal}:
+  case <-runningJob.Done:
+    w.Write([]byte("Job already finished"))
+    return
+  }
 
   timeout, err := time.ParseDuration("15s")
   select {
   case <-time.After(timeout):
     w.Write([]byte("Job could not be stopped. Times out in 15s"))
 
+  case <-runningJob.Done:
+    w.Write([]byte("Job successfully stopped"))
+
   // Spawn thread on channel traffic and go back to listening
   case comm := <-runningJob.Channel:
     if comm.Error != nil {
       http.Error(w, "{ \"Error\":\"" + comm.Error.Error() + "\"}", http.StatusBadRequest)
+      return
     }
     w.Write([]byte("Job successfully stopped"))
   }
 
   return
 }
-func runningjobTailToken(w http.ResponseWriter, r *http.Request) {
 
+// runningjobTailToken - Stream a running job's output as it is written, moving on to each retry as it starts.  Query
+//  parameters: 'stream' (stdout or stderr), 'offset' to start from a byte offset of the current attempt's output and
+//  'format=sse' (or an Accept of text/event-stream) for Server-Sent Events instead of a chunked plain text response
+func runningjobTailToken(w http.ResponseWriter, r *http.Request) {
   logrus.Debug("API request to tail job")
-  return
 
   // Convert the route variables
   vars := mux.Vars(r)
@@ -356,8 +556,225 @@ func runningjobTailToken(w http.ResponseWriter, r *http.Request) {
     return
   }
 
-  runningJob.Channel <- job.ChanComm{Signal:"tail process", Writer: w}
-  <- runningJob.Channel
+  if _, err := selectOutputStream(r, runningJob.StdOutPath(), runningJob.StdErrPath()); err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+  offset, err := parseIntQueryParam(r, "offset", 0)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  // The stream lives as long as the job so lift the server's write timeout
+  http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+  var writer outputWriter
+  if r.URL.Query().Get("format") == "sse" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    writer = newSSEWriter(w)
+  } else {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    writer = newFlushWriter(w)
+  }
+
+  // Follow each attempt's output in turn.  Every attempt logs to its own directory, so the paths are looked up again
+  //  from the tracker once the attempt being followed is over
+  for {
+    logPath, _ := selectOutputStream(r, runningJob.StdOutPath(), runningJob.StdErrPath())
+    followedAttempt := runningJob.Attempt
+    isRunning := func() (bool) {
+      currentJob, err := getRunningJobByToken(jobToken)
+      return err == nil && currentJob.Attempt == followedAttempt
+    }
+    err = job.FollowFile(r.Context(), logPath, int64(offset), writer, isRunning)
+    if err != nil {
+      logrus.Debug("Stopped tailing job " + jobToken + ": " + err.Error())
+      return
+    }
+
+    // Done once the run has left the tracker, otherwise carry on with the retry from its start
+    runningJob, err = getRunningJobByToken(jobToken)
+    if err != nil {
+      break
+    }
+    offset = 0
+  }
+  writer.Close()
+
+  return
+}
+
+// historyOutputToken - Send the captured output of a completed run.  Query parameters: 'stream' (stdout or stderr),
+//  'offset' and 'length' to select a slice of a large log.  HTTP Range requests are honored within that slice.  Logs
+//  housekeeping has compressed are only sent whole
+func historyOutputToken(w http.ResponseWriter, r *http.Request) {
+  logrus.Debug("API request for run output")
+
+  // Convert the route variables
+  vars := mux.Vars(r)
+  jobToken := vars["jobToken"]
+
+  runs, err := job.GetRunHistoryByToken(conf.Attr.HistoryPath, jobToken)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+  if len(runs) == 0 {
+    http.Error(w, "{ \"Error\":\"No completed run with token " + jobToken + "\"}", http.StatusBadRequest)
+    return
+  }
+  run := runs[len(runs) - 1]
+
+  logPath, err := selectOutputStream(r, run.StdOutPath, run.StdErrPath)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+  if logPath == "" {
+    http.Error(w, "{ \"Error\":\"Run " + jobToken + " did not capture any output\"}", http.StatusNotFound)
+    return
+  }
+
+  // Housekeeping may have compressed the log since the run was recorded
+  logFile, err := os.Open(logPath)
+  if os.IsNotExist(err) {
+    sendCompressedOutput(w, r, logPath)
+    return
+  }
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusNotFound)
+    return
+  }
+  defer logFile.Close()
+  logInfo, err := logFile.Stat()
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusNotFound)
+    return
+  }
+  logSize := logInfo.Size()
+
+  // Narrow the file down to the requested slice
+  offset, err := parseIntQueryParam(r, "offset", 0)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+  length, err := parseIntQueryParam(r, "length", int(logSize))
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+  if int64(offset) > logSize {
+    offset = int(logSize)
+  }
+  if int64(offset + length) > logSize {
+    length = int(logSize) - offset
+  }
+  section := io.NewSectionReader(logFile, int64(offset), int64(length))
+
+  // Large logs can take longer than the server's write timeout to send
+  http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+  w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  http.ServeContent(w, r, filepath.Base(logPath), logInfo.ModTime(), section)
+
+  return
+}
+
+// sendCompressedOutput - Stream a log housekeeping has compressed, decompressing it on the way rather than in memory.
+//  It can only be read from the start, so slices and Range requests are turned down
+func sendCompressedOutput(w http.ResponseWriter, r *http.Request, logPath string) {
+
+  if r.URL.Query().Get("offset") != "" || r.URL.Query().Get("length") != "" || r.Header.Get("Range") != "" {
+    http.Error(w, "{ \"Error\":\"Output has been compressed and can only be retrieved whole\"}", http.StatusRequestedRangeNotSatisfiable)
+    return
+  }
+  logFile, modTime, err := job.OpenCompressedLog(logPath)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusNotFound)
+    return
+  }
+  defer logFile.Close()
+
+  // Large logs can take longer than the server's write timeout to send
+  http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+  w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+  if _, err := io.Copy(w, logFile); err != nil {
+    logrus.Debug("Stopped sending " + logPath + ": " + err.Error())
+  }
+
+  return
+}
+
+// historyGetJob - Send a JSON representation of the completed runs of a job, oldest first.  The optional
+//  'limit' query parameter keeps only the most recent runs
+func historyGetJob(w http.ResponseWriter, r *http.Request) {
+  logrus.Debug("API request for job run history")
+
+  // Assign the JSON encoder
+  encoder := json.NewEncoder(w)
+
+  // Convert the route variables
+  vars := mux.Vars(r)
+  jobLabelStr := vars["jobLabel"]
+
+  runs, err := job.GetRunHistoryByLabel(conf.Attr.HistoryPath, jobLabelStr)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  limit, err := parseIntQueryParam(r, "limit", len(runs))
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+  if len(runs) > limit {
+    runs = runs[len(runs) - limit:]
+  }
+
+  // Return the run history in JSON format
+  err = encoder.Encode(job.RunHistoryAPI{Runs: runs})
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  return
+}
+
+// historyGetToken - Send a JSON representation of a single completed run
+func historyGetToken(w http.ResponseWriter, r *http.Request) {
+  logrus.Debug("API request for single run history")
+
+  // Assign the JSON encoder
+  encoder := json.NewEncoder(w)
+
+  // Convert the route variables
+  vars := mux.Vars(r)
+  jobToken := vars["jobToken"]
+
+  runs, err := job.GetRunHistoryByToken(conf.Attr.HistoryPath, jobToken)
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
+  if len(runs) == 0 {
+    http.Error(w, "{ \"Error\":\"No completed run with token " + jobToken + "\"}", http.StatusBadRequest)
+    return
+  }
+
+  // Return the most recent record for the token in JSON format
+  err = encoder.Encode(runs[len(runs) - 1])
+  if err != nil {
+    http.Error(w, "{ \"Error\":\"" + err.Error() + "\"}", http.StatusBadRequest)
+    return
+  }
 
   return
 }
@@ -382,6 +799,52 @@ func getRunningJobByToken(jobToken string) (job.RunningJob, error) {
   return runningJob, err
 }
 
+// selectOutputStream - Pick the stdout or stderr log from the 'stream' query parameter.  Defaults to stdout
+func selectOutputStream(r *http.Request, stdOutPath string, stdErrPath string) (string, error) {
+
+  switch r.URL.Query().Get("stream") {
+  case "", "stdout":
+    return stdOutPath, nil
+  case "stderr":
+    return stdErrPath, nil
+  default:
+    return "", errors.New("query parameter 'stream' must equal either 'stdout' or 'stderr'")
+  }
+}
+
+// parseIntQueryParam - Read a positive integer query parameter, returning the default when it is missing
+func parseIntQueryParam(r *http.Request, name string, defaultValue int) (int, error) {
+
+  valueStr := r.URL.Query().Get(name)
+  if valueStr == "" {
+    return defaultValue, nil
+  }
+  value, err := strconv.Atoi(valueStr)
+  if err != nil || value < 0 {
+    return 0, errors.New("query parameter '" + name + "' must be a positive integer")
+  }
+
+  return value, nil
+}
+
+// parseFormFieldsIntoOverrides - Read the one-off 'args' and 'env' form fields of a manual or test run
+func parseFormFieldsIntoOverrides(r *http.Request) ([]string, []string, error) {
+
+  if err := r.ParseForm(); err != nil {
+    return nil, nil, err
+  }
+
+  extraArgs := r.PostForm["args"]
+  extraEnv := r.PostForm["env"]
+  for _, envStr := range extraEnv {
+    if strings.Index(envStr, "=") < 1 {
+      return nil, nil, errors.New("form field 'env' must be in the form KEY=value: " + envStr)
+    }
+  }
+
+  return extraArgs, extraEnv, nil
+}
+
 func parseFormFieldsIntoJobConfig(newJob *job.JobConfig, r *http.Request) (error) {
 
   var err error
@@ -393,13 +856,49 @@ func parseFormFieldsIntoJobConfig(newJob *job.JobConfig, r *http.Request) (error
   } else {
     return errors.New("{ \"Error\":\"" + "Requires parameter[label]" + "\"}")
   }
+  newDependsOn, exists := r.PostForm["dependsOn"]
+  if exists == true {
+    newJob.DependsOn = nil
+    for _, dependencyStr := range newDependsOn {
+      if dependencyStr == "" {
+        continue
+      }
+      dependency, err := job.ParseDependencyString(dependencyStr)
+      if err != nil {
+        return errors.New("{ \"Error\":\"" + err.Error() + "\"}")
+      }
+      newJob.DependsOn = append(newJob.DependsOn, dependency)
+    }
+  }
+  newScheduleMode := r.PostFormValue("scheduleMode")
+  if newScheduleMode != "" {
+    newJob.ScheduleMode = newScheduleMode
+  }
+  if _, exists := r.PostForm["timezone"]; exists == true {
+    newJob.Timezone = r.PostFormValue("timezone")
+  }
+  if _, exists := r.PostForm["dstPolicy"]; exists == true {
+    newJob.DSTPolicy = r.PostFormValue("dstPolicy")
+  }
+  if _, exists := r.PostForm["catchUp"]; exists == true {
+    newJob.CatchUp = r.PostFormValue("catchUp")
+  }
+  if newCatchUpLimitStr := r.PostFormValue("catchUpLimit"); newCatchUpLimitStr != "" {
+    newCatchUpLimit, err := strconv.Atoi(newCatchUpLimitStr)
+    if err != nil {
+      return errors.New("{ \"Error\":\"form field 'catchUpLimit' must be a number\"}")
+    }
+    newJob.CatchUpLimit = newCatchUpLimit
+  }
   newScheduleStr := r.PostFormValue("schedule")
-  if newScheduleStr != "" {
+  if newScheduleStr == "" && len(newJob.DependsOn) > 0 {
+    newJob.Schedule = newScheduleStr
+  } else if newScheduleStr != "" {
     newJob.Schedule = newScheduleStr
+    newJob.Filters = nil
     err := newJob.ParseScheduleIntoFilters(false)
     if err != nil {
       return errors.New("{ \"Error\":\"" + err.Error() + "\"}")
-      return errors.New("Error parsing form fields")
     }
   } else {
     return errors.New("{ \"Error\":\"" + "Requires parameter[schedule]" + "\"}")
@@ -410,22 +909,186 @@ func parseFormFieldsIntoJobConfig(newJob *job.JobConfig, r *http.Request) (error
   } else {
     return errors.New("{ \"Error\":\"" + "Requires parameter[command]" + "\"}")
   }
+  if _, exists := r.PostForm["shell"]; exists == true {
+    newJob.Shell = r.PostFormValue("shell")
+  }
+  if _, exists := r.PostForm["user"]; exists == true {
+    newJob.User = r.PostFormValue("user")
+  }
+  if _, exists := r.PostForm["group"]; exists == true {
+    newJob.Group = r.PostFormValue("group")
+  }
   newGroupName := r.PostFormValue("groupName")
   if newGroupName != "" {
     newJob.GroupName = newGroupName
   } else {
     return errors.New("{ \"Error\":\"" + "Requires parameter[groupName]" + "\"}")
   }
+  if newMaxConcurrentStr := r.PostFormValue("maxConcurrent"); newMaxConcurrentStr != "" {
+    newMaxConcurrent, err := strconv.Atoi(newMaxConcurrentStr)
+    if err != nil {
+      return errors.New("{ \"Error\":\"form field 'maxConcurrent' must be a number\"}")
+    }
+    newJob.MaxConcurrent = newMaxConcurrent
+  }
+  if _, exists := r.PostForm["concurrencyPolicy"]; exists == true {
+    newJob.ConcurrencyPolicy = r.PostFormValue("concurrencyPolicy")
+  }
+  if newNotifyStr, exists := r.PostForm["notify"]; exists == true {
+    newJob.Notify = nil
+    if newNotifyStr[0] != "" {
+      if err := json.Unmarshal([]byte(newNotifyStr[0]), &newJob.Notify); err != nil {
+        return errors.New("{ \"Error\":\"form field 'notify' must be a JSON list of notify rules\"}")
+      }
+    }
+  }
+  if newSyslogStr, exists := r.PostForm["syslog"]; exists == true {
+    newJob.Syslog = job.SyslogConfig{}
+    if newSyslogStr[0] != "" {
+      if err := json.Unmarshal([]byte(newSyslogStr[0]), &newJob.Syslog); err != nil {
+        return errors.New("{ \"Error\":\"form field 'syslog' must be a JSON object of syslog settings\"}")
+      }
+    }
+  }
   newLocking := r.PostFormValue("locking")
   if newLocking != "" {
     if newLocking == "true" {
       newJob.Locking = true
     } else if newLocking == "false" {
-      newJob.Locking = true
+      newJob.Locking = false
     } else {
       return errors.New("{ \"Error\":\"form field 'locking' must equal either 'true' or 'false'\"}")
     }
   }
+  newEnv, exists := r.PostForm["env"]
+  if exists == true {
+    newJob.Env = nil
+    for _, envStr := range newEnv {
+      if envStr == "" {
+        continue
+      }
+      name, value, ok := job.ParseEnvLine(envStr)
+      if ok == false {
+        return errors.New("{ \"Error\":\"form field 'env' must be in the form KEY=value: " + envStr + "\"}")
+      }
+      if newJob.Env == nil {
+        newJob.Env = make(map[string]string)
+      }
+      newJob.Env[name] = value
+    }
+  }
+  if _, exists := r.PostForm["envFile"]; exists == true {
+    newJob.EnvFile = r.PostFormValue("envFile")
+  }
+  if _, exists := r.PostForm["workingDir"]; exists == true {
+    newJob.WorkingDir = r.PostFormValue("workingDir")
+  }
+  if _, exists := r.PostForm["umask"]; exists == true {
+    newJob.Umask = r.PostFormValue("umask")
+  }
+  if _, exists := r.PostForm["timeout"]; exists == true {
+    newJob.Timeout = r.PostFormValue("timeout")
+  }
+  if _, exists := r.PostForm["killGracePeriod"]; exists == true {
+    newJob.KillGracePeriod = r.PostFormValue("killGracePeriod")
+  }
+  if _, exists := r.PostForm["stopSignal"]; exists == true {
+    newJob.StopSignal = r.PostFormValue("stopSignal")
+  }
+  if _, exists := r.PostForm["memoryMax"]; exists == true {
+    newJob.MemoryMax = r.PostFormValue("memoryMax")
+  }
+  if _, exists := r.PostForm["cpuMax"]; exists == true {
+    newJob.CPUMax = r.PostFormValue("cpuMax")
+  }
+  for _, limitField := range []string{"cpuWeight", "pidsMax", "ioWeight"} {
+    limitStr := r.PostFormValue(limitField)
+    if limitStr == "" {
+      continue
+    }
+    limit, err := strconv.Atoi(limitStr)
+    if err != nil {
+      return errors.New("{ \"Error\":\"form field '" + limitField + "' must be a number\"}")
+    }
+    switch limitField {
+    case "cpuWeight":
+      newJob.CPUWeight = limit
+    case "pidsMax":
+      newJob.PidsMax = limit
+    case "ioWeight":
+      newJob.IOWeight = limit
+    }
+  }
+  if _, exists := r.PostForm["maxOutput"]; exists == true {
+    newJob.MaxOutput = r.PostFormValue("maxOutput")
+  }
+  if _, exists := r.PostForm["maxLogBytes"]; exists == true {
+    newJob.MaxLogBytes = r.PostFormValue("maxLogBytes")
+  }
+  for _, retentionField := range []string{"keepRuns", "keepDays"} {
+    retentionStr := r.PostFormValue(retentionField)
+    if retentionStr == "" {
+      continue
+    }
+    retention, err := strconv.Atoi(retentionStr)
+    if err != nil {
+      return errors.New("{ \"Error\":\"form field '" + retentionField + "' must be a number\"}")
+    }
+    if retentionField == "keepRuns" {
+      newJob.KeepRuns = retention
+    } else {
+      newJob.KeepDays = retention
+    }
+  }
+  if newRetriesStr := r.PostFormValue("retries"); newRetriesStr != "" {
+    newRetries, err := strconv.Atoi(newRetriesStr)
+    if err != nil {
+      return errors.New("{ \"Error\":\"form field 'retries' must be a number\"}")
+    }
+    newJob.Retries = newRetries
+  }
+  if _, exists := r.PostForm["retryStrategy"]; exists == true {
+    newJob.RetryBackoff.Strategy = r.PostFormValue("retryStrategy")
+  }
+  if _, exists := r.PostForm["retryDelay"]; exists == true {
+    newJob.RetryBackoff.Delay = r.PostFormValue("retryDelay")
+  }
+  if _, exists := r.PostForm["retryMaxDelay"]; exists == true {
+    newJob.RetryBackoff.MaxDelay = r.PostFormValue("retryMaxDelay")
+  }
+  newRetryJitter := r.PostFormValue("retryJitter")
+  if newRetryJitter != "" {
+    if newRetryJitter == "true" {
+      newJob.RetryBackoff.Jitter = true
+    } else if newRetryJitter == "false" {
+      newJob.RetryBackoff.Jitter = false
+    } else {
+      return errors.New("{ \"Error\":\"form field 'retryJitter' must equal either 'true' or 'false'\"}")
+    }
+  }
+  if newRetryOnExitCodes, exists := r.PostForm["retryOnExitCodes"]; exists == true {
+    newJob.RetryOnExitCodes = nil
+    for _, exitCodeStr := range newRetryOnExitCodes {
+      if exitCodeStr == "" {
+        continue
+      }
+      exitCode, err := strconv.Atoi(exitCodeStr)
+      if err != nil {
+        return errors.New("{ \"Error\":\"form field 'retryOnExitCodes' must be a number: " + exitCodeStr + "\"}")
+      }
+      newJob.RetryOnExitCodes = append(newJob.RetryOnExitCodes, exitCode)
+    }
+  }
+  newClearEnv := r.PostFormValue("clearEnv")
+  if newClearEnv != "" {
+    if newClearEnv == "true" {
+      newJob.ClearEnv = true
+    } else if newClearEnv == "false" {
+      newJob.ClearEnv = false
+    } else {
+      return errors.New("{ \"Error\":\"form field 'clearEnv' must equal either 'true' or 'false'\"}")
+    }
+  }
 
   return err
 }
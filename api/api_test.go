@@ -2,12 +2,24 @@ package api
 
 import (
   "testing"
+  "bytes"
+  "compress/gzip"
+  "context"
   "strconv"
   "io/ioutil"
+  "net"
   "net/http"
+  "net/http/httptest"
+  "os"
+  "os/user"
+  "strings"
+  "sync"
   "time"
   . "github.com/smartystreets/goconvey/convey"
+  "github.com/gorilla/mux"
   "github.com/brysearl/omicrond/conf"
+  "github.com/brysearl/omicrond/job"
+  "github.com/brysearl/omicrond/metrics"
 )
 
 func TestStartServer(t *testing.T) {
@@ -35,3 +47,311 @@ func TestStartServer(t *testing.T) {
   })
 }
 
+
+func TestStartSocketServer(t *testing.T) {
+
+  // Serve the routes on a socket in a scratch directory
+  socketDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(socketDir)
+  conf.Attr.SocketPath = socketDir + "/omicrond.sock"
+  go startSocketServer(buildRoutes(mux.NewRouter()))
+
+  // Give it a second to start
+  time.Sleep(1 * time.Second)
+
+  info, err := os.Stat(conf.Attr.SocketPath)
+  Convey("The socket should be created with the configured mode", t, func() {
+    So(err, ShouldEqual, nil)
+    So(uint32(info.Mode().Perm()), ShouldEqual, conf.Attr.SocketMode)
+  })
+
+  // Send a GET request for the /.status route without basic auth
+  client := &http.Client{Transport: &http.Transport{
+    Dial: func(network, addr string) (net.Conn, error) {
+      return net.Dial("unix", conf.Attr.SocketPath)
+    }}}
+  response, err := client.Get("http://omicrond/.status")
+
+  Convey("Should be able to query the API route /.status over the socket as the daemon user", t, func() {
+    So(err, ShouldEqual, nil)
+    body, _ := ioutil.ReadAll(response.Body)
+    So(string(body), ShouldEqual, "Omicrond is running")
+  })
+}
+
+func TestHistoryOutputToken(t *testing.T) {
+
+  // Record a completed run with captured output in a scratch directory
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.HistoryPath = scratchDir + "/history.jsonl"
+  ioutil.WriteFile(scratchDir + "/stdout.txt", []byte("line one\nline two\n"), 0644)
+  ioutil.WriteFile(scratchDir + "/stderr.txt", []byte("oops\n"), 0644)
+  job.AppendRunHistory(conf.Attr.HistoryPath, job.JobResult{
+    Token: "feedfacecafebeef",
+    Label: "Output Job",
+    StdOutPath: scratchDir + "/stdout.txt",
+    StdErrPath: scratchDir + "/stderr.txt"})
+
+  router := buildRoutes(mux.NewRouter())
+  get := func(path string, header http.Header) (*httptest.ResponseRecorder) {
+    request := httptest.NewRequest("GET", path, nil)
+    for key, values := range header {
+      request.Header[key] = values
+    }
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder, request)
+    return recorder
+  }
+
+  Convey("The full output of a completed run should be retrievable", t, func() {
+    recorder := get("/history/output/token/feedfacecafebeef", nil)
+    So(recorder.Code, ShouldEqual, http.StatusOK)
+    So(recorder.Body.String(), ShouldEqual, "line one\nline two\n")
+    recorder = get("/history/output/token/feedfacecafebeef?stream=stderr", nil)
+    So(recorder.Body.String(), ShouldEqual, "oops\n")
+  })
+
+  Convey("A slice of the output should be retrievable by offset and length or by Range", t, func() {
+    recorder := get("/history/output/token/feedfacecafebeef?offset=9&length=4", nil)
+    So(recorder.Body.String(), ShouldEqual, "line")
+    recorder = get("/history/output/token/feedfacecafebeef", http.Header{"Range": {"bytes=5-7"}})
+    So(recorder.Code, ShouldEqual, http.StatusPartialContent)
+    So(recorder.Body.String(), ShouldEqual, "one")
+  })
+
+  Convey("Compressed output should be streamed whole and slices of it refused", t, func() {
+    var compressed bytes.Buffer
+    compressor := gzip.NewWriter(&compressed)
+    compressor.Write([]byte("squeezed\n"))
+    compressor.Close()
+    ioutil.WriteFile(scratchDir + "/compressed.txt.gz", compressed.Bytes(), 0644)
+    job.AppendRunHistory(conf.Attr.HistoryPath, job.JobResult{
+      Token: "deadbeefdeadbeef",
+      Label: "Output Job",
+      StdOutPath: scratchDir + "/compressed.txt",
+      StdErrPath: scratchDir + "/missing.txt"})
+
+    recorder := get("/history/output/token/deadbeefdeadbeef", nil)
+    So(recorder.Code, ShouldEqual, http.StatusOK)
+    So(recorder.Body.String(), ShouldEqual, "squeezed\n")
+    So(get("/history/output/token/deadbeefdeadbeef?offset=2", nil).Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+    So(get("/history/output/token/deadbeefdeadbeef", http.Header{"Range": {"bytes=0-3"}}).Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+    So(get("/history/output/token/deadbeefdeadbeef?stream=stderr", nil).Code, ShouldEqual, http.StatusNotFound)
+  })
+
+  Convey("Unknown tokens and streams should return an error", t, func() {
+    So(get("/history/output/token/0000000000000000", nil).Code, ShouldEqual, http.StatusBadRequest)
+    So(get("/history/output/token/feedfacecafebeef?stream=stdin", nil).Code, ShouldEqual, http.StatusBadRequest)
+  })
+}
+
+func TestCheckRunAsAllowed(t *testing.T) {
+
+  conf.Attr.APIRunAsUsers = map[string][]string{"admin": {"*"}, "ops": {"nobody"}}
+  conf.Attr.APIUserRunAs = []string{"nobody"}
+  defer func() {
+    conf.Attr.APIRunAsUsers = map[string][]string{"root": {"*"}}
+    conf.Attr.APIUserRunAs = []string{}
+  }()
+  local := func(name string) (apiIdentity) {
+    return apiIdentity{name: name, isPeer: true}
+  }
+
+  Convey("Local users allowed any user can create jobs running as anyone", t, func() {
+    So(checkRunAsAllowed(local("admin"), job.JobConfig{Label: "Daemon"}), ShouldEqual, nil)
+    So(checkRunAsAllowed(local("admin"), job.JobConfig{Label: "Nobody", User: "nobody", Group: "root"}), ShouldEqual, nil)
+  })
+
+  Convey("Local users limited to specific users can only use those users and their groups", t, func() {
+    So(checkRunAsAllowed(local("ops"), job.JobConfig{Label: "Nobody", User: "nobody"}), ShouldEqual, nil)
+    So(checkRunAsAllowed(local("ops"), job.JobConfig{Label: "Root", User: "root"}), ShouldNotEqual, nil)
+    So(checkRunAsAllowed(local("ops"), job.JobConfig{Label: "Nobody", User: "nobody", Group: "root"}), ShouldNotEqual, nil)
+  })
+
+  Convey("Unlisted local users cannot create jobs running as the daemon", t, func() {
+    So(checkRunAsAllowed(local("guest"), job.JobConfig{Label: "Daemon"}), ShouldNotEqual, nil)
+  })
+
+  Convey("The basic auth user should only get the users listed for it, whatever its name", t, func() {
+    So(checkRunAsAllowed(apiIdentity{name: "admin"}, job.JobConfig{Label: "Nobody", User: "nobody"}), ShouldEqual, nil)
+    So(checkRunAsAllowed(apiIdentity{name: "admin"}, job.JobConfig{Label: "Daemon"}), ShouldNotEqual, nil)
+    So(checkRunAsAllowed(apiIdentity{name: "root"}, job.JobConfig{Label: "Root", User: "root"}), ShouldNotEqual, nil)
+  })
+
+  // Stand in for the scheduling loop with a schedule holding a job that runs as root
+  runningChanComm = make(chan ChanComm)
+  defer close(runningChanComm)
+  started := false
+  go func() {
+    for comm := range runningChanComm {
+      switch comm.Signal {
+      case "scheduleGetList":
+        runningChanComm <- ChanComm{RunningSchedule: job.JobSchedule{
+          Job: []job.JobConfig{{Label: "Root", Command: "/bin/date", User: "root"}},
+          LabelToIndex: map[string]int{"Root": 0}}}
+      case "runJob":
+        started = true
+        runningChanComm <- ChanComm{Token: "feedfacecafebeef"}
+      }
+    }
+  }()
+  router := buildRoutes(mux.NewRouter())
+  post := func(request *http.Request) (*httptest.ResponseRecorder) {
+    request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder, request)
+    return recorder
+  }
+  basicAuth := func(path string, caller string) (*http.Request) {
+    request := httptest.NewRequest("POST", path, strings.NewReader("args=-c&execute=false"))
+    request.SetBasicAuth(caller, "")
+    return request
+  }
+  peer := func(path string) (*http.Request) {
+    request := httptest.NewRequest("POST", path, strings.NewReader("args=-c&execute=false"))
+    cred := &peerCred{PID: int32(os.Getpid()), UID: uint32(os.Geteuid()), GID: uint32(os.Getegid())}
+    return request.WithContext(context.WithValue(request.Context(), peerCredContextKey, cred))
+  }
+
+  Convey("The basic auth user cannot run or test jobs as local users its name matches", t, func() {
+    So(post(basicAuth("/schedule/run/job/Root", "root")).Code, ShouldEqual, http.StatusForbidden)
+    So(post(basicAuth("/schedule/test/job/Root", "root")).Code, ShouldEqual, http.StatusForbidden)
+    So(started, ShouldEqual, false)
+  })
+
+  Convey("Local users allowed the job's user can run it with arguments", t, func() {
+    daemonUser, err := user.Current()
+    So(err, ShouldEqual, nil)
+    conf.Attr.APIRunAsUsers[daemonUser.Username] = []string{"root"}
+    So(post(peer("/schedule/run/job/Root")).Code, ShouldEqual, http.StatusOK)
+    So(started, ShouldEqual, true)
+  })
+}
+
+func TestTailRetriedRun(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  for attempt, output := range map[string]string{"1": "first attempt\n", "2": "second attempt\n"} {
+    os.MkdirAll(scratchDir + "/" + attempt, 0755)
+    ioutil.WriteFile(scratchDir + "/" + attempt + "/stdout.txt", []byte(output), 0644)
+  }
+
+  // Stand in for the scheduling loop with a run that is retried and then finishes as the tail keeps checking on it
+  runningChanComm = make(chan ChanComm)
+  defer close(runningChanComm)
+  go func() {
+    requests := 0
+    for comm := range runningChanComm {
+      if comm.Signal != "runningjobGetList" {
+        continue
+      }
+      requests++
+      tracker := job.RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]job.RunningJob)}
+      switch {
+      case requests <= 3:
+        tracker.Jobs["feedfacecafebeef"] = job.RunningJob{Token: "feedfacecafebeef", Attempt: 1, LogDir: scratchDir + "/1"}
+      case requests <= 6:
+        tracker.Jobs["feedfacecafebeef"] = job.RunningJob{Token: "feedfacecafebeef", Attempt: 2, LogDir: scratchDir + "/2"}
+      }
+      runningChanComm <- ChanComm{RunningJobs: tracker}
+    }
+  }()
+
+  Convey("Tailing a retried run should carry on with the output of each attempt", t, func() {
+    request := httptest.NewRequest("GET", "/runningjob/tail/token/feedfacecafebeef", nil)
+    recorder := httptest.NewRecorder()
+    buildRoutes(mux.NewRouter()).ServeHTTP(recorder, request)
+    So(recorder.Code, ShouldEqual, http.StatusOK)
+    So(recorder.Body.String(), ShouldEqual, "first attempt\nsecond attempt\n")
+  })
+}
+
+func TestParseFormFieldsIntoJobConfig(t *testing.T) {
+
+  required := "label=Locked&schedule=*+*+*+*+*&command=/bin/date&groupName=Unit+Tests"
+  form := func(body string) (*http.Request) {
+    request := httptest.NewRequest("POST", "/schedule/edit/job/Locked", strings.NewReader(body))
+    request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    return request
+  }
+
+  Convey("Locking should be switchable both on and off", t, func() {
+    lockedJob := job.JobConfig{Label: "Locked", Command: "/bin/date", Locking: true}
+    So(parseFormFieldsIntoJobConfig(&lockedJob, form(required + "&locking=false")), ShouldEqual, nil)
+    So(lockedJob.Locking, ShouldEqual, false)
+    So(parseFormFieldsIntoJobConfig(&lockedJob, form(required + "&locking=true")), ShouldEqual, nil)
+    So(lockedJob.Locking, ShouldEqual, true)
+    So(parseFormFieldsIntoJobConfig(&lockedJob, form(required + "&locking=maybe")), ShouldNotEqual, nil)
+  })
+}
+
+func TestRejectedScheduleChanges(t *testing.T) {
+
+  conf.Attr.APIUserRunAs = []string{"*"}
+  defer func() {
+    conf.Attr.APIUserRunAs = []string{}
+  }()
+
+  // Stand in for the scheduling loop, handing out the same running schedule on every request
+  running := job.JobSchedule{
+    Job: []job.JobConfig{
+      {Label: "Upstream", Schedule: "* * * * *", Command: "/bin/date", GroupName: "Unit Tests"},
+      {Label: "Downstream", Command: "/bin/date", GroupName: "Unit Tests",
+        DependsOn: []job.JobDependency{{Label: "Upstream"}}}},
+    LabelToIndex: map[string]int{"Upstream": 0, "Downstream": 1}}
+  runningChanComm = make(chan ChanComm)
+  defer close(runningChanComm)
+  replaced := false
+  go func() {
+    for comm := range runningChanComm {
+      switch comm.Signal {
+      case "scheduleGetList":
+        runningChanComm <- ChanComm{RunningSchedule: running}
+      case "replaceRunningSchedule":
+        replaced = true
+      }
+    }
+  }()
+  router := buildRoutes(mux.NewRouter())
+  send := func(path string, body string) (*httptest.ResponseRecorder) {
+    request := httptest.NewRequest("POST", path, strings.NewReader(body))
+    request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    request.SetBasicAuth("admin", "")
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder, request)
+    return recorder
+  }
+
+  Convey("A rejected edit should leave the running schedule untouched", t, func() {
+    body := "label=Downstream&schedule=*+*+*+*+*&command=/bin/date&groupName=Unit+Tests"
+    So(send("/schedule/edit/job/Upstream", body).Code, ShouldEqual, http.StatusBadRequest)
+    So(running.Job[0].Label, ShouldEqual, "Upstream")
+    So(replaced, ShouldEqual, false)
+  })
+
+  Convey("A rejected delete should leave the running schedule untouched", t, func() {
+    So(send("/schedule/delete/job/Upstream", "").Code, ShouldEqual, http.StatusBadRequest)
+    So(running.Job[0].Label, ShouldEqual, "Upstream")
+    So(running.Job[1].Label, ShouldEqual, "Downstream")
+    So(replaced, ShouldEqual, false)
+  })
+}
+
+func TestGetMetrics(t *testing.T) {
+
+  metrics.Clear()
+  defer metrics.Clear()
+  metrics.RunStarted("Nightly Backup")
+  metrics.RunFinished("Nightly Backup", true, time.Unix(1700000000, 0), time.Unix(1700000090, 0))
+
+  Convey("Metrics should be served in the Prometheus text format", t, func() {
+    recorder := httptest.NewRecorder()
+    buildRoutes(mux.NewRouter()).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+    So(recorder.Code, ShouldEqual, http.StatusOK)
+    So(recorder.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+    So(recorder.Body.String(), ShouldContainSubstring, "omicrond_runs_started_total{label=\"Nightly Backup\"} 1\n")
+    So(recorder.Body.String(), ShouldContainSubstring, "omicrond_last_success_timestamp_seconds{label=\"Nightly Backup\"} 1.70000009e+09\n")
+  })
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+  "bytes"
+  "io"
+  "net/http"
+)
+
+// outputWriter - Destination for followed job output.  Close writes anything still buffered
+type outputWriter interface {
+  io.Writer
+  Close() (error)
+}
+
+// flushWriter - Chunked plain text that is flushed to the client after every write
+type flushWriter struct {
+  w http.ResponseWriter
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+  return &flushWriter{w: w}
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+
+  n, err := f.w.Write(p)
+  if flusher, ok := f.w.(http.Flusher); ok {
+    flusher.Flush()
+  }
+
+  return n, err
+}
+
+func (f *flushWriter) Close() (error) {
+  return nil
+}
+
+// sseWriter - Server-Sent Events with one event per line of output.  Partial lines are held until completed
+type sseWriter struct {
+  w       http.ResponseWriter
+  partial []byte
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+  return &sseWriter{w: w}
+}
+
+func (s *sseWriter) Write(p []byte) (int, error) {
+
+  s.partial = append(s.partial, p...)
+  for {
+    lineEnd := bytes.IndexByte(s.partial, '\n')
+    if lineEnd < 0 {
+      break
+    }
+    if err := s.writeEvent(s.partial[:lineEnd]); err != nil {
+      return 0, err
+    }
+    s.partial = s.partial[lineEnd + 1:]
+  }
+  if flusher, ok := s.w.(http.Flusher); ok {
+    flusher.Flush()
+  }
+
+  return len(p), nil
+}
+
+func (s *sseWriter) Close() (error) {
+
+  if len(s.partial) > 0 {
+    if err := s.writeEvent(s.partial); err != nil {
+      return err
+    }
+    s.partial = nil
+  }
+  _, err := s.w.Write([]byte("event: end\ndata: \n\n"))
+  if flusher, ok := s.w.(http.Flusher); ok {
+    flusher.Flush()
+  }
+
+  return err
+}
+
+func (s *sseWriter) writeEvent(line []byte) (error) {
+
+  _, err := s.w.Write(append(append([]byte("data: "), bytes.TrimSuffix(line, []byte("\r"))...), '\n', '\n'))
+  return err
+}
@@ -0,0 +1,37 @@
+// +build linux
+
+package api
+
+import (
+  "errors"
+  "net"
+  "golang.org/x/sys/unix"
+)
+
+// getPeerCred - Read the SO_PEERCRED credentials of a unix socket connection
+func getPeerCred(conn net.Conn) (*peerCred, error) {
+
+  unixConn, ok := conn.(*net.UnixConn)
+  if !ok {
+    return nil, errors.New("Not a unix socket connection")
+  }
+
+  rawConn, err := unixConn.SyscallConn()
+  if err != nil {
+    return nil, err
+  }
+
+  var ucred *unix.Ucred
+  var credErr error
+  err = rawConn.Control(func(fd uintptr) {
+    ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+  })
+  if err != nil {
+    return nil, err
+  }
+  if credErr != nil {
+    return nil, credErr
+  }
+
+  return &peerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
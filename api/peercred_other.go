@@ -0,0 +1,14 @@
+// +build !linux
+
+package api
+
+import (
+  "errors"
+  "net"
+)
+
+// getPeerCred - SO_PEERCRED is only available on linux.  Other platforms always fall back to basic auth
+func getPeerCred(conn net.Conn) (*peerCred, error) {
+
+  return nil, errors.New("Peer credentials are not supported on this platform")
+}
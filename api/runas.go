@@ -0,0 +1,90 @@
+package api
+
+import (
+  "errors"
+  "net/http"
+  "os/user"
+  "strconv"
+  "github.com/brysearl/omicrond/conf"
+  "github.com/brysearl/omicrond/job"
+)
+
+// apiIdentity - Who is making a request.  Authorized unix socket peers are local users known by their peer
+//  credentials.  Everyone else is the shared basic auth user, whose name says nothing about who they are locally
+type apiIdentity struct {
+  name   string
+  isPeer bool
+}
+
+// String - Name of the caller as it appears in errors
+func (caller apiIdentity) String() (string) {
+
+  if caller.isPeer == true {
+    return "Local user [" + caller.name + "]"
+  }
+
+  return "API user [" + caller.name + "]"
+}
+
+// apiCaller - Identity of the user making the request
+func apiCaller(r *http.Request) (apiIdentity) {
+
+  if cred, ok := r.Context().Value(peerCredContextKey).(*peerCred); ok && isPeerAuthorized(cred) {
+    peerUser, err := user.LookupId(strconv.Itoa(int(cred.UID)))
+    if err != nil {
+      return apiIdentity{name: strconv.Itoa(int(cred.UID)), isPeer: true}
+    }
+    return apiIdentity{name: peerUser.Username, isPeer: true}
+  }
+  username, _, _ := r.BasicAuth()
+
+  return apiIdentity{name: username}
+}
+
+// checkRunAsAllowed - Local users may always create and run jobs that run as themselves, and any user APIRunAsUsers
+//  lists for them.  The basic auth user may only use the users in APIUserRunAs.  That includes the daemon's own user
+//  for jobs without a User.  Callers limited to specific users can only pick a Group the user belongs to
+func checkRunAsAllowed(caller apiIdentity, newJob job.JobConfig) (error) {
+
+  runAs, err := newJob.RunAsUser()
+  if err != nil {
+    return errors.New("Cannot find user the job runs as: " + err.Error())
+  }
+
+  allowed := false
+  allowedUsers := conf.Attr.APIUserRunAs
+  if caller.isPeer == true {
+    allowed = caller.name == runAs.Username
+    allowedUsers = conf.Attr.APIRunAsUsers[caller.name]
+  }
+  for _, allowedUser := range allowedUsers {
+    if allowedUser == "*" {
+      return nil
+    }
+    if allowedUser == runAs.Username || allowedUser == runAs.Uid {
+      allowed = true
+    }
+  }
+  if allowed == false {
+    return errors.New(caller.String() + " may not create or run jobs as [" + runAs.Username + "]")
+  }
+
+  if newJob.Group == "" {
+    return nil
+  }
+  runAsGroup, err := user.LookupGroup(newJob.Group)
+  if err != nil {
+    runAsGroup, err = user.LookupGroupId(newJob.Group)
+    if err != nil {
+      return errors.New("Cannot find group the job runs as: " + newJob.Group)
+    }
+  }
+  groupIds, _ := runAs.GroupIds()
+  for _, groupId := range append(groupIds, runAs.Gid) {
+    if groupId == runAsGroup.Gid {
+      return nil
+    }
+  }
+
+  return errors.New(caller.String() + " may not create or run jobs as group [" + newJob.Group + "]")
+}
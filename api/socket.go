@@ -0,0 +1,189 @@
+package api
+
+import (
+  "context"
+  "errors"
+  "net"
+  "net/http"
+  "os"
+  "os/user"
+  "strconv"
+  "time"
+  "github.com/Sirupsen/logrus"
+  "github.com/gorilla/mux"
+  "github.com/brysearl/omicrond/conf"
+  "github.com/goji/httpauth"
+)
+
+// peerCred - Credentials of the process on the other end of a unix socket connection
+type peerCred struct {
+  PID int32
+  UID uint32
+  GID uint32
+}
+
+type contextKey string
+
+const peerCredContextKey = contextKey("peerCred")
+
+// startSocketServer - Serve the routes on the unix socket configured in conf.go.  Should be run in a goroutine
+func startSocketServer(router *mux.Router) {
+
+  listener, err := listenOnSocket(conf.Attr.SocketPath)
+  if err != nil {
+    logrus.Fatal(err)
+  }
+
+  logrus.Info("Starting unix socket interface: " + conf.Attr.SocketPath)
+  srv := &http.Server{
+    Handler:      peerCredAuth(router),
+    ConnContext:  attachPeerCred,
+    WriteTimeout: time.Duration(conf.Attr.APITimeout) * time.Second,
+    ReadTimeout:  time.Duration(conf.Attr.APITimeout) * time.Second,
+  }
+
+  logrus.Fatal(srv.Serve(listener))
+}
+
+// listenOnSocket - Create the unix socket, replacing a stale one, and apply the configured mode and ownership
+func listenOnSocket(socketPath string) (net.Listener, error) {
+
+  // Clear out a socket left behind by a previous run
+  if info, err := os.Lstat(socketPath); err == nil {
+    if info.Mode() & os.ModeSocket == 0 {
+      return nil, errors.New("Refusing to replace non-socket file: " + socketPath)
+    }
+    if err := os.Remove(socketPath); err != nil {
+      return nil, err
+    }
+  }
+
+  listener, err := net.Listen("unix", socketPath)
+  if err != nil {
+    return nil, err
+  }
+
+  if err := os.Chmod(socketPath, os.FileMode(conf.Attr.SocketMode)); err != nil {
+    listener.Close()
+    return nil, err
+  }
+
+  if conf.Attr.SocketOwner != "" || conf.Attr.SocketGroup != "" {
+    uid, gid := -1, -1
+    if conf.Attr.SocketOwner != "" {
+      uid, err = lookupUID(conf.Attr.SocketOwner)
+      if err != nil {
+        listener.Close()
+        return nil, err
+      }
+    }
+    if conf.Attr.SocketGroup != "" {
+      gid, err = lookupGID(conf.Attr.SocketGroup)
+      if err != nil {
+        listener.Close()
+        return nil, err
+      }
+    }
+    if err := os.Chown(socketPath, uid, gid); err != nil {
+      listener.Close()
+      return nil, err
+    }
+  }
+
+  return listener, nil
+}
+
+// attachPeerCred - Store the credentials of the connecting process in the request context
+func attachPeerCred(ctx context.Context, conn net.Conn) context.Context {
+
+  cred, err := getPeerCred(conn)
+  if err != nil {
+    logrus.Debug("Could not read peer credentials: " + err.Error())
+    return ctx
+  }
+
+  return context.WithValue(ctx, peerCredContextKey, cred)
+}
+
+// peerCredAuth - Let authorized local peers through and fall back to basic auth for everyone else
+func peerCredAuth(next http.Handler) http.Handler {
+
+  basicAuth := httpauth.SimpleBasicAuth(conf.Attr.APIUser, conf.Attr.APIPassword)(next)
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    cred, ok := r.Context().Value(peerCredContextKey).(*peerCred)
+    if ok && isPeerAuthorized(cred) {
+      logrus.Debug("API request authorized for uid " + strconv.Itoa(int(cred.UID)) + " pid " + strconv.Itoa(int(cred.PID)))
+      next.ServeHTTP(w, r)
+      return
+    }
+    basicAuth.ServeHTTP(w, r)
+  })
+}
+
+// isPeerAuthorized - Peers running as the daemon user, an allowed user or a member of an allowed group are authorized
+func isPeerAuthorized(cred *peerCred) bool {
+
+  if int(cred.UID) == os.Geteuid() {
+    return true
+  }
+
+  peerUser, err := user.LookupId(strconv.Itoa(int(cred.UID)))
+  if err != nil {
+    return false
+  }
+  for _, allowedUser := range conf.Attr.SocketAllowedUsers {
+    if allowedUser == peerUser.Username || allowedUser == peerUser.Uid {
+      return true
+    }
+  }
+
+  if len(conf.Attr.SocketAllowedGroups) == 0 {
+    return false
+  }
+  groupIds, err := peerUser.GroupIds()
+  if err != nil {
+    groupIds = []string{}
+  }
+  groupIds = append(groupIds, strconv.Itoa(int(cred.GID)))
+  for _, allowedGroup := range conf.Attr.SocketAllowedGroups {
+    allowedGid, err := lookupGID(allowedGroup)
+    if err != nil {
+      continue
+    }
+    for _, groupId := range groupIds {
+      if groupId == strconv.Itoa(allowedGid) {
+        return true
+      }
+    }
+  }
+
+  return false
+}
+
+// lookupUID - Resolve a user name or numeric uid
+func lookupUID(name string) (int, error) {
+
+  if uid, err := strconv.Atoi(name); err == nil {
+    return uid, nil
+  }
+  u, err := user.Lookup(name)
+  if err != nil {
+    return -1, err
+  }
+
+  return strconv.Atoi(u.Uid)
+}
+
+// lookupGID - Resolve a group name or numeric gid
+func lookupGID(name string) (int, error) {
+
+  if gid, err := strconv.Atoi(name); err == nil {
+    return gid, nil
+  }
+  g, err := user.LookupGroup(name)
+  if err != nil {
+    return -1, err
+  }
+
+  return strconv.Atoi(g.Gid)
+}
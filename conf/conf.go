@@ -6,7 +6,29 @@ type DaemonConfig struct {
   SocketPath    string
   JobConfigPath string
   LoggingPath   string
+  HistoryPath   string
+  LogMaxOutput  string // Most of each output stream kept per run (ex '10M').  Empty keeps everything
+  LogKeepRuns   int    // Most runs whose logs are kept per job.  0 keeps them all
+  LogKeepDays   int    // Days the logs of a run are kept.  0 keeps them forever
+  LogMaxBytes   string // Most space all job logs may take up (ex '10G').  Empty is unlimited
+  LogCompress   bool   // Gzip the logs of finished runs
+  LogHousekeepingInterval int // Minutes between passes over LoggingPath to compress and remove old logs
+  HistoryKeepRuns int  // Most runs kept per job in the run history, trimmed along with the logs.  0 keeps them all
+  HistoryKeepDays int  // Days runs are kept in the run history.  0 keeps them forever
+  LastEvaluatedPath string // Last time the scheduling loop evaluated jobs, used to catch up on missed runs
+  ScheduleMode  string // Default evaluation of job schedules: legacy or cron
+  MaxConcurrent int    // Most jobs running at once across the daemon.  0 is unlimited
+  CgroupRoot    string // cgroup v2 slice runs of jobs with resource limits get their own cgroup under.  Empty disables cgroups
+  SMTPAddress   string // host:port of the relay notification emails are sent through
+  SMTPFrom      string // Sender of notification emails
+  SMTPUser      string // User to authenticate to the relay as.  Empty sends without authenticating
+  SMTPPassword  string
   LogLevel      int
+  LogFormat     string // Format of the daemon's log: text or json
+  LogOutput     string // Where the daemon logs to: stderr, syslog (the /dev/log socket journald also reads) or a file path
+  LogJobOutput  bool   // Log each line of job output as an info event rather than only when debugging
+  LogForward    []string // Also send the daemon's log to syslog and/or the journal
+  JobLogForward []string // Where job output is forwarded when a job doesn't say: syslog and/or journal
   Port          int
   APIAddress    string
   APIPort       int
@@ -16,6 +38,14 @@ type DaemonConfig struct {
   APISSL         bool
   APIPubKeyPath  string
   APIPrivKeyPath string
+  APIRunAsUsers  map[string][]string // Users each local user on the unix socket may create or run jobs as.  '*' allows any user
+  APIUserRunAs   []string            // Users the basic auth APIUser may create or run jobs as.  '*' allows any user
+  APISocket           bool     // Serve the API on the unix socket at SocketPath
+  SocketMode          uint32   // File mode of the unix socket
+  SocketOwner         string   // User name or uid to own the unix socket.  Empty leaves the daemon user
+  SocketGroup         string   // Group name or gid to own the unix socket.  Empty leaves the daemon group
+  SocketAllowedUsers  []string // Peers connecting as these users skip basic auth on the unix socket
+  SocketAllowedGroups []string // Peers connecting as members of these groups skip basic auth on the unix socket
 }
 
 var Attr = DaemonConfig{}
@@ -27,7 +57,31 @@ func init() {
   Attr.SocketPath = Attr.BaseDir + "/omicrond.sock"
   Attr.JobConfigPath = Attr.BaseDir + "/sample/sampleJobConf.toml"
   Attr.LoggingPath = Attr.BaseDir + "/logs"
+  Attr.HistoryPath = Attr.BaseDir + "/history.jsonl"
+  Attr.LogMaxOutput = ""
+  Attr.LogKeepRuns = 0
+  Attr.LogKeepDays = 0
+  Attr.LogMaxBytes = ""
+  Attr.LogCompress = true
+  Attr.LogHousekeepingInterval = 10
+  Attr.HistoryKeepRuns = 1000
+  Attr.HistoryKeepDays = 0
+  Attr.LastEvaluatedPath = Attr.BaseDir + "/last_evaluated"
+  Attr.ScheduleMode = "legacy"
+  Attr.MaxConcurrent = 0
+  // Only jobs with resource limits run in a cgroup.  Whatever such a run leaves running, even if detached with nohup,
+  //  setsid or &, is killed when it ends.  Jobs without limits run outside of cgroups and may leave processes behind
+  Attr.CgroupRoot = "/sys/fs/cgroup/omicrond"
+  Attr.SMTPAddress = "localhost:25"
+  Attr.SMTPFrom = "omicrond@localhost"
+  Attr.SMTPUser = ""
+  Attr.SMTPPassword = ""
   Attr.LogLevel = 0
+  Attr.LogFormat = "text"
+  Attr.LogOutput = "stderr"
+  Attr.LogJobOutput = false
+  Attr.LogForward = []string{}
+  Attr.JobLogForward = []string{}
   Attr.Port = 51515
   Attr.APIAddress = "localhost"
   Attr.APIPort = 12221
@@ -37,4 +91,12 @@ func init() {
   Attr.APISSL = false
   Attr.APIPubKeyPath = Attr.BaseDir + "/etc/omicrond_api.crt"
   Attr.APIPrivKeyPath = Attr.BaseDir + "/etc/omicrond_api.key"
+  Attr.APIRunAsUsers = map[string][]string{"root": {"*"}}
+  Attr.APIUserRunAs = []string{}
+  Attr.APISocket = false
+  Attr.SocketMode = 0660
+  Attr.SocketOwner = ""
+  Attr.SocketGroup = ""
+  Attr.SocketAllowedUsers = []string{"root"}
+  Attr.SocketAllowedGroups = []string{}
 }
\ No newline at end of file
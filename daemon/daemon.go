@@ -3,9 +3,11 @@ package daemon
 import (
   "time"
   "errors"
+  "strconv"
   "github.com/Sirupsen/logrus"
   "github.com/brysearl/omicrond/conf"
   "github.com/brysearl/omicrond/job"
+  "github.com/brysearl/omicrond/metrics"
   "github.com/brysearl/omicrond/api"
   "sync"
 )
@@ -34,91 +36,187 @@ func StartDaemon() {
 // startSchedulingLoop - Endless loop that checks jobs every minute and executes them if scheduled
 func startSchedulingLoop(schedule job.JobSchedule, jobConfig string) {
 
-  // Keep track of the last minute that was run.  This way we can sit quietly until the next minute comes.
-  lastCheckTime := time.Now().Truncate(time.Minute)
+  // Keep track of the last minute (or second, if any job has a seconds field) that was run.  This way we can sit
+  //  quietly until the next one comes.  The resolution only changes along with the schedule
+  resolution := schedule.Resolution()
+  lastCheckTime := time.Now().Truncate(resolution)
 
   // Keep track of running jobs
   Running := job.RunningJobTracker{}
   Running.Sync = new(sync.RWMutex)
   Running.Jobs = make(map[string]job.RunningJob)
 
+  // Keep track of completed runs to resolve job dependencies.
+  // Seeded from the run history so upstream runs are neither lost nor reused across restarts
+  completedJobs := make(chan job.JobResult)
+  lastTriggered := make(map[string]time.Time)
+  lastResults, err := job.GetLastRunResults(conf.Attr.HistoryPath)
+  if err != nil {
+    logrus.Error("Could not read run history: " + err.Error())
+  }
+  for label, result := range lastResults {
+    lastTriggered[label] = result.StartTime
+  }
+
+  // Jobs that haven't succeeded since before a restart still report their last success
+  lastSuccesses, err := job.GetLastSuccessTimes(conf.Attr.HistoryPath)
+  if err != nil {
+    logrus.Error("Could not read run history: " + err.Error())
+  }
+  for label, endTime := range lastSuccesses {
+    metrics.SetLastSuccess(label, endTime)
+  }
+
+  // Staggered jobs are handed back to the loop when it is their turn to start
+  queuedJobs := make(chan job.RunningJob)
+
+  // Pick up from the last time a previous daemon evaluated jobs, so the gap counts as missed and is caught up on
+  pendingCatchUps := make(map[string][]time.Time)
+  lastEvaluated, err := job.ReadLastEvaluated(conf.Attr.LastEvaluatedPath)
+  if err != nil {
+    logrus.Error("Could not read the last evaluated time: " + err.Error())
+  } else if lastEvaluated.IsZero() == false && lastEvaluated.Before(lastCheckTime) {
+    lastCheckTime = lastEvaluated
+  }
+  var lastRecordedMinute time.Time
+
+  // Old logs are cleaned up in the background, one pass at a time
+  housekeeping := make(chan bool, 1)
+  var lastHousekeeping time.Time
+
+  // @reboot jobs run once as the daemon comes up
+  for jobIndex, _ := range schedule.Job {
+    if schedule.Job[jobIndex].IsRebootJob() {
+      schedule.Job[jobIndex].Logger().Info("[" + schedule.Job[jobIndex].Label + "] starting at boot")
+      newJob := job.RunningJob{Config: schedule.Job[jobIndex], Trigger: job.TRIGGERREBOOT}
+      triggerTime := time.Now()
+      _, err := startJob(newJob, schedule, &Running, completedJobs)
+      if err == nil {
+        lastTriggered[schedule.Job[jobIndex].Label] = triggerTime
+      }
+    }
+  }
+
   // To infinity, and beyond
   for {
 
-    // Get the current minute with the seconds rounded down
-    currentTime := time.Now().Truncate(time.Minute)
+    // Get the current minute with the seconds rounded down, or the current second for six field schedules
+    currentTime := time.Now().Truncate(resolution)
 
     // Wait patiently for a new minute
     if currentTime != lastCheckTime {
 
+      // Note how late in the tick jobs are being evaluated
+      metrics.SetLoopLag(time.Since(currentTime))
+
+      // A gap of more than one tick means the daemon was down, the host slept or the clock jumped forward.  Jobs
+      //  with a CatchUp policy make up for what they missed one run at a time
+      if currentTime.Sub(lastCheckTime) > resolution {
+        caughtUpRuns, caughtUpJobs := 0, 0
+        for jobIndex, _ := range schedule.Job {
+          // A minute boundary evaluated below this tick isn't also caught up
+          missedBefore, isChecked := schedule.Job[jobIndex].CheckTime(lastCheckTime, currentTime)
+          if isChecked == false {
+            missedBefore = currentTime
+          }
+          missedRuns := schedule.Job[jobIndex].MissedRuns(lastCheckTime, missedBefore)
+          if len(missedRuns) == 0 {
+            continue
+          }
+          label := schedule.Job[jobIndex].Label
+          schedule.Job[jobIndex].Logger().Debug("[" + label + "] catching up on " + strconv.Itoa(len(missedRuns)) + " missed run(s)")
+          caughtUpRuns += len(missedRuns)
+          caughtUpJobs++
+          isIdle := len(pendingCatchUps[label]) == 0
+          pendingCatchUps[label] = append(pendingCatchUps[label], missedRuns...)
+          if isIdle {
+            queueCatchUp(schedule, label, pendingCatchUps, lastTriggered, queuedJobs)
+          }
+        }
+
+        // One line per gap.  Six field schedules slip a second now and then, which is only worth a warning when
+        //  something was missed because of it
+        gapSummary := "Missed evaluating jobs for " + currentTime.Sub(lastCheckTime).String() + " between " +
+          lastCheckTime.String() + " and " + currentTime.String() + ".  Catching up on " + strconv.Itoa(caughtUpRuns) +
+          " run(s) of " + strconv.Itoa(caughtUpJobs) + " job(s)"
+        if caughtUpRuns > 0 || currentTime.Sub(lastCheckTime) >= time.Minute {
+          logrus.Warn(gapSummary)
+        } else {
+          logrus.Debug(gapSummary)
+        }
+      }
+
       //Check each configured job to see if it needs to be run in this minute
       logrus.Debug("Running filters: " + currentTime.String())
+      groupDue := make(map[string][]job.RunningJob)
       for jobIndex, _ := range schedule.Job {
 
+        // Five field schedules are checked once for each minute passed, even if the loop got to it late
+        checkTime, isChecked := schedule.Job[jobIndex].CheckTime(lastCheckTime, currentTime)
+        if isChecked == false {
+          continue
+        }
+
         logrus.Debug("Checking: " + schedule.Job[jobIndex].Label)
-        runJob := schedule.Job[jobIndex].CheckIfScheduled(currentTime)
+        runJob := schedule.Job[jobIndex].IsDue(checkTime)
 
         if runJob == true {
 
-          // Check to see if its running and skip if locking attribute enabled
-          if schedule.Job[jobIndex].Locking == true {
-            var skip bool
-            Running.Sync.RLock()
-            for runToken, _ := range Running.Jobs {
-              if schedule.Job[jobIndex].Label == Running.Jobs[runToken].Config.Label {
-                skip = true
-                break
-              }
-            }
-            Running.Sync.RUnlock()
-
-            if skip {
-              logrus.Info("[" + schedule.Job[jobIndex].Label + "] currently running and locked.  Skipping.")
+          // Scheduled jobs with dependencies still wait on their upstream jobs
+          if len(schedule.Job[jobIndex].DependsOn) > 0 {
+            if schedule.Job[jobIndex].DependenciesMet(lastResults, lastTriggered[schedule.Job[jobIndex].Label], currentTime) == false {
+              schedule.Job[jobIndex].Logger().Info("[" + schedule.Job[jobIndex].Label + "] dependencies not met.  Skipping.")
               continue
             }
           }
 
-          // Prep the Job for Running and create a tracking token
-          runToken := job.CreateRunToken()
-          newJob := job.RunningJob{
-            Token: runToken,
-            Config: schedule.Job[jobIndex],
-            Channel: make(chan job.ChanComm),
-            StartTime: time.Now()}
-
-          // Add the tracking token to the tracker
-          logrus.Debug("Adding job " + runToken + " to tracker")
-          Running.Sync.Lock()
-          Running.Jobs[runToken] = newJob
-          Running.Sync.Unlock()
-
-          // Split off the job into a goroutine
-          go func(Running *job.RunningJobTracker, newJob job.RunningJob, runToken string, isUnitTest bool) {
+          // Jobs in a configured group are staggered across the group's window after every due job is known
+          newJob := job.RunningJob{Config: schedule.Job[jobIndex], Trigger: job.TRIGGERSCHEDULE}
+          if _, isGrouped := schedule.GetGroup(newJob.Config.GroupName); isGrouped {
+            groupDue[newJob.Config.GroupName] = append(groupDue[newJob.Config.GroupName], newJob)
+            lastTriggered[newJob.Config.Label] = time.Now()
+            continue
+          }
 
-            // Start the job
-            if isUnitTest != true {
-              newJob.Run(Running)
-            }
+          triggerTime := time.Now()
+          _, err := startJob(newJob, schedule, &Running, completedJobs)
+          if err == nil {
+            lastTriggered[schedule.Job[jobIndex].Label] = triggerTime
+          }
+        }
+      }
 
-            // On completion, remove the tracking token from the tracker
-            Running.Sync.RLock()
-            _, ok := Running.Jobs[runToken]
-            Running.Sync.RUnlock()
-
-            if ok {
-              logrus.Debug("Removing job " + runToken + " from tracker")
-              Running.Sync.Lock()
-              delete(Running.Jobs, runToken)
-              Running.Sync.Unlock()
-            } else {
-              logrus.Error("Could not find runToken on completion")
-            }
-          }(&Running, newJob, runToken, isUnitTest)
+      // Spread each group's jobs across its start window
+      for groupName, dueJobs := range groupDue {
+        group, _ := schedule.GetGroup(groupName)
+        for dueIndex, delay := range group.StaggerDelays(len(dueJobs)) {
+          dueJobs[dueIndex].Logger().Debug("[" + dueJobs[dueIndex].Config.Label + "] staggered by " + delay.String() + " in group [" + groupName + "]")
+          go queueJob(dueJobs[dueIndex], currentTime.Add(delay), queuedJobs)
         }
       }
 
-      // Update the minute lock and take a break
+      // Update the minute lock and take a break.  The evaluated time is only recorded once the minute moves forward
+      //  so six field schedules don't rewrite it every second.  A restart may catch up on the seconds since again
       lastCheckTime = currentTime
+      if isUnitTest != true && currentTime.Truncate(time.Minute).After(lastRecordedMinute) {
+        if err := job.WriteLastEvaluated(conf.Attr.LastEvaluatedPath, currentTime); err != nil {
+          logrus.Error("Could not record the last evaluated time: " + err.Error())
+        } else {
+          lastRecordedMinute = currentTime.Truncate(time.Minute)
+        }
+      }
+
+      // Compress and remove old logs once the interval has passed and the last pass is done
+      housekeepingInterval := time.Duration(conf.Attr.LogHousekeepingInterval) * time.Minute
+      if isUnitTest != true && housekeepingInterval > 0 && currentTime.Sub(lastHousekeeping) >= housekeepingInterval {
+        select {
+        case housekeeping <- true:
+          lastHousekeeping = currentTime
+          go housekeepLogs(append([]job.JobConfig(nil), schedule.Job...), &Running, housekeeping)
+        default:
+          logrus.Debug("Log housekeeping is still running")
+        }
+      }
 
     } else {
 
@@ -127,7 +225,7 @@ func startSchedulingLoop(schedule job.JobSchedule, jobConfig string) {
       for stop == false {
 
         // Determine the amount of free time available to listen to a channel
-        timeout := time.Now().Truncate(time.Minute).Add(time.Minute).Sub(time.Now())
+        timeout := time.Now().Truncate(resolution).Add(resolution).Sub(time.Now())
         logrus.Debug("Listening to channel for the next " + timeout.String() + " seconds")
 
         select {
@@ -137,9 +235,69 @@ func startSchedulingLoop(schedule job.JobSchedule, jobConfig string) {
           logrus.Debug("No longer listing on channel")
           stop = true
 
+        // Record completed runs and trigger any downstream jobs whose dependencies are now met
+        case result := <-completedJobs:
+          if result.Trigger == job.TRIGGERCATCHUP {
+            queueCatchUp(schedule, result.Label, pendingCatchUps, lastTriggered, queuedJobs)
+          }
+
+          // Runs stopped while queued never ran, so they neither count as a result nor trigger anything
+          if result.StartTime.IsZero() {
+            continue
+          }
+          lastResults[result.Label] = result
+          for _, jobIndex := range schedule.GetDownstreamJobs(result.Label) {
+            downstreamJob := schedule.Job[jobIndex]
+            if downstreamJob.DependenciesMet(lastResults, lastTriggered[downstreamJob.Label], time.Now()) {
+              downstreamJob.Logger().Info("[" + downstreamJob.Label + "] dependencies met by [" + result.Label + "]")
+              newJob := job.RunningJob{Config: downstreamJob, Trigger: job.TRIGGERDEPENDENCY}
+
+              // Grouped jobs still have to wait for room in their group
+              if _, isGrouped := schedule.GetGroup(downstreamJob.GroupName); isGrouped {
+                lastTriggered[downstreamJob.Label] = time.Now()
+                go queueJob(newJob, time.Now(), queuedJobs)
+                continue
+              }
+
+              triggerTime := time.Now()
+              _, err := startJob(newJob, schedule, &Running, completedJobs)
+              if err == nil {
+                lastTriggered[downstreamJob.Label] = triggerTime
+              }
+            }
+          }
+
+        // Start staggered jobs and catch up runs once it is their turn
+        case queuedJob := <-queuedJobs:
+          _, err := startJob(queuedJob, schedule, &Running, completedJobs)
+          if err != nil && queuedJob.Trigger == job.TRIGGERCATCHUP {
+            queueCatchUp(schedule, queuedJob.Config.Label, pendingCatchUps, lastTriggered, queuedJobs)
+          }
+
         // Spawn thread on channel traffic and go back to listening
         case incomingChanComm := <-runningChanComm:
 
+          // Replacing the schedule has to happen here so the loop itself picks up the change
+          if incomingChanComm.Signal == "replaceRunningSchedule" {
+            err := incomingChanComm.RunningSchedule.CheckConfig()
+            if err != nil {
+              logrus.Error(err)
+              continue
+            }
+
+            logrus.Debug("Schedule Refreshed")
+            if isUnitTest != true {
+              incomingChanComm.RunningSchedule.WriteJobConfig(jobConfig)
+            }
+            schedule = incomingChanComm.RunningSchedule
+            resolution = schedule.Resolution()
+            metrics.ScheduleReloaded()
+
+            // Raised limits may make room for queued runs
+            Running.AdmitQueued(schedule, conf.Attr.MaxConcurrent)
+            continue
+          }
+
         // Spawn thread so we can get back to listening
           Running.Sync.RLock()
           go func(schedule job.JobSchedule, running job.RunningJobTracker) {
@@ -150,18 +308,11 @@ func startSchedulingLoop(schedule job.JobSchedule, jobConfig string) {
               runningChanComm <- api.ChanComm{RunningSchedule: schedule, Signal: "scheduleGetList"}
             case "runningjobGetList":
               runningChanComm <- api.ChanComm{RunningJobs: running, Signal: "runningjobGetList"}
-            case "replaceRunningSchedule":
-              // Replace the running schedule with that of the requestor
-              err := incomingChanComm.RunningSchedule.CheckConfig()
-              if err != nil {
-                logrus.Error(err)
-              }
-
-              logrus.Debug("Schedule Refreshed")
-              if isUnitTest != true {
-                incomingChanComm.RunningSchedule.WriteJobConfig(jobConfig)
-              }
-              schedule = incomingChanComm.RunningSchedule
+            case "runJob":
+              // Start a job outside of its schedule
+              incomingChanComm.RunJob.Logger().Info("[" + incomingChanComm.RunJob.Config.Label + "] manually triggered")
+              runToken, err := startJob(incomingChanComm.RunJob, schedule, &running, completedJobs)
+              runningChanComm <- api.ChanComm{Token: runToken, Error: err, Signal: "runJob"}
             case "shutdown":
               logrus.Info("Recieved shutdown command.  Goodbye...")
               return
@@ -175,3 +326,164 @@ func startSchedulingLoop(schedule job.JobSchedule, jobConfig string) {
     }
   }
 }
+
+// housekeepLogs - One housekeeping pass over the logging path and the run history.  Frees up the housekeeping slot
+//  when done
+func housekeepLogs(jobs []job.JobConfig, Running *job.RunningJobTracker, housekeeping chan bool) {
+
+  defer func() { <-housekeeping }()
+  err := job.HousekeepLogs(conf.Attr.LoggingPath, jobs, Running.ActiveTokens(), time.Now())
+  if err != nil {
+    logrus.Error("Log housekeeping failed: " + err.Error())
+  }
+  err = job.TrimRunHistory(conf.Attr.HistoryPath, conf.Attr.HistoryKeepRuns, conf.Attr.HistoryKeepDays, time.Now())
+  if err != nil {
+    logrus.Error("Could not trim the run history: " + err.Error())
+  }
+}
+
+// queueJob - Hand a job back to the scheduling loop to be started once startAt has passed
+func queueJob(newJob job.RunningJob, startAt time.Time, queuedJobs chan job.RunningJob) {
+
+  time.Sleep(startAt.Sub(time.Now()))
+  queuedJobs <- newJob
+}
+
+// queueCatchUp - Queue the job's oldest pending catch up run.  Called again when that run finishes or is skipped, so a
+//  job's catch up runs never overlap each other
+func queueCatchUp(schedule job.JobSchedule, label string, pendingCatchUps map[string][]time.Time, lastTriggered map[string]time.Time, queuedJobs chan job.RunningJob) {
+
+  missedRuns := pendingCatchUps[label]
+  if len(missedRuns) == 0 {
+    return
+  }
+  if len(missedRuns) == 1 {
+    delete(pendingCatchUps, label)
+  } else {
+    pendingCatchUps[label] = missedRuns[1:]
+  }
+
+  // The job may have been removed from the schedule since it missed its runs
+  catchUpConfig, _, err := schedule.GetJobByLabel(label)
+  if err != nil {
+    logrus.Info("[" + label + "] no longer scheduled.  Dropping its catch up runs.")
+    delete(pendingCatchUps, label)
+    return
+  }
+
+  catchUpConfig.Logger().Info("[" + label + "] catching up on the run missed at " + missedRuns[0].String())
+  lastTriggered[label] = time.Now()
+  go queueJob(job.RunningJob{Config: catchUpConfig, Trigger: job.TRIGGERCATCHUP, MissedAt: missedRuns[0]}, time.Now(), queuedJobs)
+}
+
+// startJob - Add a job to the tracker and run it in a goroutine, returning its run token.  The job is held to its own,
+//  its group's and the daemon's concurrency limits.  Returns an error if the job was skipped because of one.  Queued
+//  runs are tracked under their token and start, oldest first, once a finished run makes room for them.  The result
+//  of the run is sent to completedJobs once the job exits.
+func startJob(newJob job.RunningJob, schedule job.JobSchedule, Running *job.RunningJobTracker, completedJobs chan job.JobResult) (string, error) {
+
+  // Prep the Job for Running and create a tracking token
+  newJob.Token = job.CreateRunToken()
+  runToken := newJob.Token
+  newJob.Channel = make(chan job.ChanComm)
+  newJob.Admitted = make(chan bool)
+  newJob.Done = make(chan bool)
+  newJob.Attempt = 1
+  newJob.StartTime = time.Now()
+  newJob.LogDir = newJob.DetermineLoggingDir()
+
+  // Add the tracking token to the tracker if there is room under every limit, otherwise apply the job's policy to
+  //  the first one that is full.  Replacing stops the oldest run and queues this one for the slot it frees
+  limit, oldestToken := Running.AddWithinLimits(&newJob, schedule, conf.Attr.MaxConcurrent)
+  if limit != "" {
+    switch newJob.Config.LimitPolicy(limit) {
+    case job.LIMITQUEUE:
+      newJob.Logger().Info("[" + newJob.Config.Label + "] reached its " + limit + " concurrency limit.  Queued as " + runToken)
+    case job.LIMITREPLACE:
+      newJob.Logger().Info("[" + newJob.Config.Label + "] reached its " + limit + " concurrency limit.  Stopping " + oldestToken + " to make room for " + runToken)
+      go func(Running *job.RunningJobTracker, oldestToken string) {
+        if err := Running.StopRun(oldestToken); err != nil {
+          logrus.Error("Could not stop " + oldestToken + ": " + err.Error())
+        }
+      }(Running, oldestToken)
+    default:
+      newJob.Logger().Info("[" + newJob.Config.Label + "] reached its " + limit + " concurrency limit.  Skipping.")
+      metrics.RunSkipped(newJob.Config.Label, limit)
+      return "", errors.New("Job [" + newJob.Config.Label + "] reached its " + limit + " concurrency limit")
+    }
+  }
+  newJob.Logger().Debug("Added job " + runToken + " to tracker")
+  metrics.SetRunning(Running.CountRunning())
+
+  // Split off the job into a goroutine
+  go func(Running *job.RunningJobTracker, newJob job.RunningJob, runToken string, isUnitTest bool) {
+
+    // Queued runs wait for the tracker to make room for them.  Runs stopped while queued never start
+    var result job.JobResult
+    started := true
+    if newJob.Queued == true {
+      started = newJob.WaitForRoom()
+      if started == true {
+        newJob.Queued = false
+        newJob.StartTime = time.Now()
+        Running.Update(newJob)
+        metrics.SetRunning(Running.CountRunning())
+      }
+    }
+    for started == true {
+      // Start the job
+      metrics.RunStarted(newJob.Config.Label)
+      if isUnitTest != true {
+        newJob.Run(Running)
+      } else {
+        newJob.EndTime = time.Now()
+      }
+
+      // Record every attempt of the run
+      result = newJob.Result()
+      metrics.RunFinished(result.Label, result.Status == job.STATUSSUCCEEDED, result.StartTime, result.EndTime)
+      if isUnitTest != true {
+        if err := job.AppendRunHistory(conf.Attr.HistoryPath, result); err != nil {
+          newJob.Logger().Error("Could not record run history: " + err.Error())
+        }
+      }
+
+      // Failed runs keep their token and their place in the tracker while waiting to retry, so locking still
+      //  applies and the API can see the pending attempt
+      if newJob.Config.ShouldRetry(result) == false {
+        break
+      }
+      retryDelay := newJob.Config.RetryDelay(newJob.Attempt)
+      newJob.Logger().Info("[" + newJob.Config.Label + "] attempt " + strconv.Itoa(newJob.Attempt) + " " + result.Status + ".  Retrying in " + retryDelay.String())
+      newJob.RetryAt = time.Now().Add(retryDelay)
+      Running.Update(newJob)
+      if newJob.WaitForRetry(retryDelay) == false {
+        newJob.Logger().Info("[" + newJob.Config.Label + "] retries of " + runToken + " cancelled")
+        break
+      }
+      newJob.PrepareNextAttempt()
+      Running.Update(newJob)
+    }
+
+    // Pass word of how the run ended on, whether it ran out of retries, succeeded or had its retries cancelled
+    if started == true {
+      newJob.Notify()
+    } else {
+      newJob.Logger().Info("[" + newJob.Config.Label + "] " + runToken + " stopped before it left the queue")
+      result = newJob.Result()
+    }
+
+    // On completion, remove the tracking token from the tracker, letting in whatever was queued behind the run
+    newJob.Logger().Debug("Removing job " + runToken + " from tracker")
+    if Running.Remove(runToken, schedule, conf.Attr.MaxConcurrent) == false {
+      newJob.Logger().Error("Could not find runToken on completion")
+    }
+    metrics.SetRunning(Running.CountRunning())
+    close(newJob.Done)
+
+    // Let the scheduling loop resolve any downstream jobs once the final attempt is done
+    completedJobs <- result
+  }(Running, newJob, runToken, isUnitTest)
+
+  return runToken, nil
+}
@@ -29,6 +29,9 @@ func TestApiRoutes(t *testing.T) {
   conf.Attr.APIPort = 47685
   conf.Attr.APISSL = false
 
+  // The shared API user has to be granted the users it creates and runs jobs as
+  conf.Attr.APIUserRunAs = []string{"*"}
+
   // Start the daemon
   go StartDaemon()
 
@@ -136,6 +139,56 @@ func TestApiRoutes(t *testing.T) {
   runApiTest(t,resp,true)
   resp.Body.Close()
 
+  // scheduleRunJob Route test success
+  logrus.Info("Testing scheduleRunJob")
+  request, _ = http.NewRequest("POST","http://" + conf.Attr.APIAddress + ":" + strconv.Itoa(conf.Attr.APIPort) + "/schedule/run/job/Echo",
+    bytes.NewBufferString(url.Values{
+      "args": {"world"},
+      "env": {"GREETING=hello"}}.Encode()))
+  request.SetBasicAuth(conf.Attr.APIUser, conf.Attr.APIPassword)
+  request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+  resp, err = client.Do(request)
+  Convey("Should be able to build a Get request using daemon conf", t, func() {
+    So(err, ShouldEqual, nil)
+  })
+  runApiTest(t,resp,true)
+  resp.Body.Close()
+
+  // scheduleRunJob Route test failure
+  logrus.Info("Testing scheduleRunJob with a malformed env override")
+  request, _ = http.NewRequest("POST","http://" + conf.Attr.APIAddress + ":" + strconv.Itoa(conf.Attr.APIPort) + "/schedule/run/job/Echo",
+    bytes.NewBufferString(url.Values{
+      "env": {"GREETING"}}.Encode()))
+  request.SetBasicAuth(conf.Attr.APIUser, conf.Attr.APIPassword)
+  request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+  resp, err = client.Do(request)
+  Convey("Should be able to build a Get request using daemon conf", t, func() {
+    So(err, ShouldEqual, nil)
+  })
+  runApiTest(t,resp,false)
+  resp.Body.Close()
+
+  // scheduleTestJob Route test success
+  logrus.Info("Testing scheduleTestJob")
+  request, _ = http.NewRequest("POST","http://" + conf.Attr.APIAddress + ":" + strconv.Itoa(conf.Attr.APIPort) + "/schedule/test/job/Echo",
+    bytes.NewBufferString(url.Values{
+      "args": {"world"}}.Encode()))
+  request.SetBasicAuth(conf.Attr.APIUser, conf.Attr.APIPassword)
+  request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+  resp, err = client.Do(request)
+  Convey("Should be able to build a Get request using daemon conf", t, func() {
+    So(err, ShouldEqual, nil)
+  })
+  var dryRun job.DryRunResult
+  json.NewDecoder(resp.Body).Decode(&dryRun)
+  resp.Body.Close()
+  Convey("A test run should execute the command and return its output", t, func() {
+    So(dryRun.Error, ShouldEqual, "")
+    So(dryRun.Executed, ShouldEqual, true)
+    So(dryRun.ExitCode, ShouldEqual, 0)
+    So(dryRun.StdOut, ShouldEqual, "hello world\n")
+  })
+
   // Turn off the daemon
   runningChanComm <- api.ChanComm{Signal: "shutdown", RunningSchedule: job.JobSchedule{} }
 }
@@ -0,0 +1,112 @@
+package job
+
+import (
+  "errors"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const (
+  CATCHUPNONE = "none"
+  CATCHUPONCE = "once"
+  CATCHUPALL = "all"
+)
+
+// defaultCatchUpLimit - Most missed runs an 'all' catch up makes up for when CatchUpLimit is empty
+const defaultCatchUpLimit = 10
+
+// catchUpWindow - Furthest back missed runs are looked for.  Runs missed before then are dropped
+const catchUpWindow = 7 * 24 * time.Hour
+
+// catchUpWindowSeconds - catchUpWindow for six field schedules, which are checked every second
+const catchUpWindowSeconds = time.Hour
+
+// checkCatchUp - Make sure the catch up policy is one we know
+func (j *JobConfig) checkCatchUp() (error) {
+
+  if j.CatchUp != "" && j.CatchUp != CATCHUPNONE && j.CatchUp != CATCHUPONCE && j.CatchUp != CATCHUPALL {
+    return errors.New("Config error: Job [" + j.Label + "] catchUp must be one of 'none', 'once' or 'all'")
+  }
+  if j.CatchUpLimit < 0 {
+    return errors.New("Config error: Job [" + j.Label + "] catchUpLimit cannot be negative: " + strconv.Itoa(j.CatchUpLimit))
+  }
+
+  return nil
+}
+
+// MissedRuns - Occurrences of the job between after and before, both exclusive, that its CatchUp policy makes up for.
+//  'once' gives only the latest, 'all' the latest CatchUpLimit in the order they were due.  Only the last
+//  catchUpWindow (catchUpWindowSeconds for six field schedules) before before is looked at
+func (j *JobConfig) MissedRuns(after time.Time, before time.Time) ([]time.Time) {
+
+  if j.CatchUp == "" || j.CatchUp == CATCHUPNONE {
+    return nil
+  }
+  limit := 1
+  if j.CatchUp == CATCHUPALL {
+    limit = j.CatchUpLimit
+    if limit == 0 {
+      limit = defaultCatchUpLimit
+    }
+  }
+
+  step, window := time.Minute, catchUpWindow
+  if j.HasSecondsField() {
+    step, window = time.Second, catchUpWindowSeconds
+  }
+  if windowStart := before.Add(-window); after.Before(windowStart) {
+    after = windowStart
+  }
+
+  // Walk back from the most recent occurrence so outages only cost as many checks as it takes to fill the limit
+  var missed []time.Time
+  checkTime := before.Truncate(step)
+  if checkTime.Before(before) == false {
+    checkTime = checkTime.Add(-step)
+  }
+  for ; checkTime.After(after) && len(missed) < limit; checkTime = checkTime.Add(-step) {
+    if j.IsDue(checkTime) {
+      missed = append([]time.Time{checkTime}, missed...)
+    }
+  }
+
+  return missed
+}
+
+// ReadLastEvaluated - The last time the scheduling loop recorded evaluating jobs.  Zero if it never has
+func ReadLastEvaluated(lastEvaluatedPath string) (time.Time, error) {
+
+  rawTime, err := ioutil.ReadFile(lastEvaluatedPath)
+  if os.IsNotExist(err) {
+    return time.Time{}, nil
+  } else if err != nil {
+    return time.Time{}, err
+  }
+
+  return time.Parse(time.RFC3339, strings.TrimSpace(string(rawTime)))
+}
+
+// WriteLastEvaluated - Record the time the scheduling loop evaluated jobs.  Written to a temporary file and renamed into
+//  place so a crash never leaves half a timestamp behind
+func WriteLastEvaluated(lastEvaluatedPath string, evaluatedTime time.Time) (error) {
+
+  tempFile, err := ioutil.TempFile(filepath.Dir(lastEvaluatedPath), filepath.Base(lastEvaluatedPath) + ".")
+  if err != nil {
+    return err
+  }
+  _, err = tempFile.WriteString(evaluatedTime.Format(time.RFC3339) + "\n")
+  closeErr := tempFile.Close()
+  if err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    os.Remove(tempFile.Name())
+    return err
+  }
+
+  return os.Rename(tempFile.Name(), lastEvaluatedPath)
+}
@@ -0,0 +1,289 @@
+package job
+
+import (
+  "bufio"
+  "errors"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "regexp"
+  "strconv"
+  "strings"
+  "sync/atomic"
+  "syscall"
+  "time"
+  "github.com/brysearl/omicrond/conf"
+)
+
+// cgroupControllers - Controllers the daemon's slice hands down to the cgroup of each run
+var cgroupControllers = []string{"memory", "cpu", "io", "pids"}
+
+// cgroupFDUnsupported - Set once the kernel turns out unable to start commands straight into a cgroup.  Cloning into
+//  a cgroup needs clone3 with CLONE_INTO_CGROUP, added in linux 5.7
+var cgroupFDUnsupported int32
+
+// byteSizeRegex - A byte count with an optional binary suffix (ex '512M', '2G')
+var byteSizeRegex = regexp.MustCompile("^([0-9]+)([KMGT]?)$")
+
+// CgroupUsage - Resources used so far by everything in a run's cgroup
+type CgroupUsage struct {
+  Memory  int64                 // Bytes of memory currently charged to the run
+  CPUTime time.Duration         // CPU time used by the run and its children
+  Pids    int                   // Processes and threads currently in the run
+}
+
+// checkResources - Make sure the resource limits are ones cgroup v2 understands
+func (j *JobConfig) checkResources() (error) {
+
+  if j.HasResourceLimits() && conf.Attr.CgroupRoot == "" {
+    return errors.New("Config error: Job [" + j.Label + "] has resource limits but cgroups are disabled")
+  }
+  if j.MemoryMax != "" {
+    if _, err := parseMemoryMax(j.MemoryMax); err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] " + err.Error())
+    }
+  }
+  if j.CPUMax != "" {
+    if _, err := parseCPUMax(j.CPUMax); err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] " + err.Error())
+    }
+  }
+  if j.CPUWeight < 0 || j.CPUWeight > 10000 {
+    return errors.New("Config error: Job [" + j.Label + "] cpuWeight must be between 1 and 10000: " + strconv.Itoa(j.CPUWeight))
+  }
+  if j.IOWeight < 0 || j.IOWeight > 10000 {
+    return errors.New("Config error: Job [" + j.Label + "] ioWeight must be between 1 and 10000: " + strconv.Itoa(j.IOWeight))
+  }
+  if j.PidsMax < 0 {
+    return errors.New("Config error: Job [" + j.Label + "] pidsMax cannot be negative: " + strconv.Itoa(j.PidsMax))
+  }
+
+  return nil
+}
+
+// HasResourceLimits - Whether any cgroup limit is set on the job
+func (j *JobConfig) HasResourceLimits() (bool) {
+  return j.MemoryMax != "" || j.CPUMax != "" || j.CPUWeight > 0 || j.IOWeight > 0 || j.PidsMax > 0
+}
+
+// parseMemoryMax - Convert a memory limit (ex '512M', 'max') into the value written to memory.max
+func parseMemoryMax(memoryMax string) (string, error) {
+
+  if memoryMax == "max" {
+    return memoryMax, nil
+  }
+  bytes, err := parseByteSize(memoryMax)
+  if err != nil {
+    return "", errors.New("memoryMax " + err.Error())
+  }
+
+  return strconv.FormatInt(bytes, 10), nil
+}
+
+// parseByteSize - Convert a byte count with an optional K, M, G or T suffix (ex '512M') into bytes
+func parseByteSize(size string) (int64, error) {
+
+  matches := byteSizeRegex.FindStringSubmatch(strings.ToUpper(size))
+  if matches == nil {
+    return 0, errors.New("must be a number of bytes with an optional K, M, G or T suffix: " + size)
+  }
+  bytes, err := strconv.ParseInt(matches[1], 10, 64)
+  shift := uint(strings.Index("KMGT", matches[2]) + 1) * 10
+  if matches[2] == "" {
+    shift = 0
+  }
+  if err != nil || bytes > (1 << 62) >> shift {
+    return 0, errors.New("is too large: " + size)
+  }
+
+  return bytes << shift, nil
+}
+
+// parseCPUMax - Convert a CPU limit into the value written to cpu.max.  Takes a percentage of one CPU (ex '150%'),
+//  cgroup's own '<quota> <period>' in microseconds or 'max'
+func parseCPUMax(cpuMax string) (string, error) {
+
+  if cpuMax == "max" {
+    return cpuMax, nil
+  }
+  if strings.HasSuffix(cpuMax, "%") {
+    percent, err := strconv.Atoi(strings.TrimSuffix(cpuMax, "%"))
+    if err != nil || percent <= 0 {
+      return "", errors.New("cpuMax must be a positive percentage of one CPU: " + cpuMax)
+    }
+    return strconv.Itoa(percent * 1000) + " 100000", nil
+  }
+
+  fields := strings.Fields(cpuMax)
+  if len(fields) == 2 {
+    quota, quotaErr := strconv.Atoi(fields[0])
+    period, periodErr := strconv.Atoi(fields[1])
+    if (fields[0] == "max" || (quotaErr == nil && quota > 0)) && periodErr == nil && period > 0 {
+      return fields[0] + " " + fields[1], nil
+    }
+  }
+
+  return "", errors.New("cpuMax must be a percentage (ex '50%'), '<quota> <period>' or 'max': " + cpuMax)
+}
+
+// cgroupName - Directory name of the run's cgroup under the daemon's slice.  Each attempt gets its own
+func (r *RunningJob) cgroupName() (string) {
+
+  name := strings.Replace(r.Config.Label, " ", "_", -1) + "-" + r.Token
+  if r.Attempt > 1 {
+    name += "-" + strconv.Itoa(r.Attempt)
+  }
+
+  return strings.Replace(name, "/", "_", -1)
+}
+
+// createCgroup - Create the run's cgroup under the root slice and write the job's limits into it.  Controllers are
+//  enabled on the slice one at a time so a missing one only fails the jobs that limit it
+func (r *RunningJob) createCgroup(cgroupRoot string) (string, error) {
+
+  if err := os.MkdirAll(cgroupRoot, 0755); err != nil {
+    return "", err
+  }
+  for _, controller := range cgroupControllers {
+    ioutil.WriteFile(filepath.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+" + controller), 0644)
+  }
+
+  cgroupPath := filepath.Join(cgroupRoot, r.cgroupName())
+  if err := os.Mkdir(cgroupPath, 0755); err != nil {
+    return "", err
+  }
+
+  limits := make(map[string]string)
+  if r.Config.MemoryMax != "" {
+    limits["memory.max"], _ = parseMemoryMax(r.Config.MemoryMax)
+  }
+  if r.Config.CPUMax != "" {
+    limits["cpu.max"], _ = parseCPUMax(r.Config.CPUMax)
+  }
+  if r.Config.CPUWeight > 0 {
+    limits["cpu.weight"] = strconv.Itoa(r.Config.CPUWeight)
+  }
+  if r.Config.IOWeight > 0 {
+    limits["io.weight"] = "default " + strconv.Itoa(r.Config.IOWeight)
+  }
+  if r.Config.PidsMax > 0 {
+    limits["pids.max"] = strconv.Itoa(r.Config.PidsMax)
+  }
+  for limitFile, value := range limits {
+    if err := ioutil.WriteFile(filepath.Join(cgroupPath, limitFile), []byte(value), 0644); err != nil {
+      os.Remove(cgroupPath)
+      return "", errors.New("Cannot set " + limitFile + " for [" + r.Config.Label + "]: " + err.Error())
+    }
+  }
+
+  return cgroupPath, nil
+}
+
+// joinCgroup - Create the run's cgroup and have the command start inside it, so nothing it spawns can escape the
+//  limits.  Only jobs with resource limits get a cgroup.  Everything else runs outside of one so processes it means to
+//  leave running outlive it.  The returned directory has to stay open until the command has started
+func (r *RunningJob) joinCgroup(cmd *exec.Cmd) (*os.File, error) {
+
+  if r.Config.HasResourceLimits() == false {
+    return nil, nil
+  }
+  cgroupRoot := conf.Attr.CgroupRoot
+  if cgroupRoot == "" || isCgroup2(filepath.Dir(cgroupRoot)) == false {
+    return nil, errors.New("Cannot apply resource limits to [" + r.Config.Label + "]: cgroup v2 is not mounted above " + cgroupRoot)
+  }
+  if atomic.LoadInt32(&cgroupFDUnsupported) == 1 {
+    return nil, errors.New("Cannot apply resource limits to [" + r.Config.Label + "]: the kernel cannot start commands in a cgroup")
+  }
+
+  cgroupPath, err := r.createCgroup(cgroupRoot)
+  if err != nil {
+    return nil, err
+  }
+  cgroupDir, err := os.Open(cgroupPath)
+  if err != nil {
+    os.Remove(cgroupPath)
+    return nil, err
+  }
+  if err = attachCgroup(cmd, cgroupDir); err != nil {
+    cgroupDir.Close()
+    os.Remove(cgroupPath)
+    return nil, err
+  }
+  r.CgroupPath = cgroupPath
+
+  return cgroupDir, nil
+}
+
+// startInCgroup - Start the command inside the cgroup joinCgroup attached it to.  Kernels too old to clone into a
+//  cgroup turn the start down with ENOSYS or EINVAL.  The run fails rather than run unbounded and later runs with
+//  limits fail without trying
+func (r *RunningJob) startInCgroup(cmd *exec.Cmd, cgroupDir *os.File) (error) {
+
+  err := r.Config.startCommand(cmd)
+  if cgroupDir == nil {
+    return err
+  }
+  cgroupDir.Close()
+  if err == nil || (errors.Is(err, syscall.ENOSYS) == false && errors.Is(err, syscall.EINVAL) == false) {
+    return err
+  }
+
+  os.Remove(r.CgroupPath)
+  r.CgroupPath = ""
+  atomic.StoreInt32(&cgroupFDUnsupported, 1)
+
+  return errors.New("Cannot apply resource limits to [" + r.Config.Label + "]: the kernel cannot start commands in a cgroup: " + err.Error())
+}
+
+// removeCgroup - Kill whatever the job left running in its cgroup, including processes detached with nohup, setsid
+//  or &, and remove the cgroup once it empties.  Kernels without cgroup.kill (older than 5.14) get the job's process
+//  group killed instead.  Runs outside of a cgroup are left alone
+func (r *RunningJob) removeCgroup() {
+
+  if r.CgroupPath == "" {
+    return
+  }
+  if killFile, err := os.OpenFile(filepath.Join(r.CgroupPath, "cgroup.kill"), os.O_WRONLY, 0); err == nil {
+    killFile.Write([]byte("1"))
+    killFile.Close()
+  } else if r.PID > 0 {
+    syscall.Kill(-r.PID, syscall.SIGKILL)
+  }
+
+  // Killed processes take a moment to leave the cgroup
+  var err error
+  for removeAttempt := 0; removeAttempt < 50; removeAttempt++ {
+    if err = os.Remove(r.CgroupPath); err == nil || os.IsNotExist(err) {
+      return
+    }
+    time.Sleep(20 * time.Millisecond)
+  }
+  r.Logger().Warn("Could not remove the cgroup of [" + r.Config.Label + "]: " + err.Error())
+}
+
+// readCgroupUsage - Read the memory, CPU time and process count of a run's cgroup.  Controllers that aren't enabled
+//  read as zero
+func readCgroupUsage(cgroupPath string) (CgroupUsage) {
+
+  var usage CgroupUsage
+  if rawMemory, err := ioutil.ReadFile(filepath.Join(cgroupPath, "memory.current")); err == nil {
+    usage.Memory, _ = strconv.ParseInt(strings.TrimSpace(string(rawMemory)), 10, 64)
+  }
+  if rawPids, err := ioutil.ReadFile(filepath.Join(cgroupPath, "pids.current")); err == nil {
+    usage.Pids, _ = strconv.Atoi(strings.TrimSpace(string(rawPids)))
+  }
+  if cpuStat, err := os.Open(filepath.Join(cgroupPath, "cpu.stat")); err == nil {
+    scanner := bufio.NewScanner(cpuStat)
+    for scanner.Scan() {
+      fields := strings.Fields(scanner.Text())
+      if len(fields) == 2 && fields[0] == "usage_usec" {
+        usec, _ := strconv.ParseInt(fields[1], 10, 64)
+        usage.CPUTime = time.Duration(usec) * time.Microsecond
+      }
+    }
+    cpuStat.Close()
+  }
+
+  return usage
+}
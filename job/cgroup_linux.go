@@ -0,0 +1,35 @@
+// +build linux
+
+package job
+
+import (
+  "os"
+  "os/exec"
+  "syscall"
+)
+
+// cgroup2SuperMagic - Filesystem type of a cgroup v2 mount
+const cgroup2SuperMagic = 0x63677270
+
+// isCgroup2 - Whether the path is on a cgroup v2 mount
+func isCgroup2(path string) (bool) {
+
+  var stat syscall.Statfs_t
+  if err := syscall.Statfs(path, &stat); err != nil {
+    return false
+  }
+
+  return stat.Type == cgroup2SuperMagic
+}
+
+// attachCgroup - Have the command cloned straight into the cgroup so it is limited from its first instruction
+func attachCgroup(cmd *exec.Cmd, cgroupDir *os.File) (error) {
+
+  if cmd.SysProcAttr == nil {
+    cmd.SysProcAttr = &syscall.SysProcAttr{}
+  }
+  cmd.SysProcAttr.UseCgroupFD = true
+  cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+
+  return nil
+}
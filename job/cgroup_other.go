@@ -0,0 +1,19 @@
+// +build !linux
+
+package job
+
+import (
+  "errors"
+  "os"
+  "os/exec"
+)
+
+// isCgroup2 - cgroups only exist on linux
+func isCgroup2(path string) (bool) {
+  return false
+}
+
+// attachCgroup - cgroups only exist on linux
+func attachCgroup(cmd *exec.Cmd, cgroupDir *os.File) (error) {
+  return errors.New("cgroups are only available on linux")
+}
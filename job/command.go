@@ -0,0 +1,105 @@
+package job
+
+import (
+  "errors"
+  "strings"
+)
+
+// SplitCommandWords - Split a command line into words the way a POSIX shell would, honoring single quotes, double
+//  quotes and backslash escapes.  Nothing is expanded, and operators such as pipes or redirections are kept as
+//  plain words.  Set Shell on the job to have them interpreted
+func SplitCommandWords(command string) ([]string, error) {
+
+  var words []string
+  var word strings.Builder
+  inWord := false // Quotes can make a word without adding any characters to it ('')
+  runes := []rune(command)
+
+  for i := 0; i < len(runes); i++ {
+    switch runes[i] {
+
+    // Outside of quotes a backslash keeps the next character as is.  An escaped newline joins the lines
+    case '\\':
+      inWord = true
+      if i + 1 < len(runes) {
+        i++
+        if runes[i] != '\n' {
+          word.WriteRune(runes[i])
+        }
+      } else {
+        word.WriteRune(runes[i])
+      }
+
+    // Everything up to the closing single quote is literal
+    case '\'':
+      inWord = true
+      closing := strings.IndexRune(string(runes[i + 1:]), '\'')
+      if closing < 0 {
+        return nil, errors.New("Unterminated single quote in command: " + command)
+      }
+      quoted := []rune(string(runes[i + 1:])[:closing])
+      word.WriteString(string(quoted))
+      i += len(quoted) + 1
+
+    // Within double quotes a backslash only escapes $ ` " \ and newlines
+    case '"':
+      inWord = true
+      for i++; i < len(runes) && runes[i] != '"'; i++ {
+        if runes[i] == '\\' && i + 1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i + 1]) {
+          i++
+          if runes[i] == '\n' {
+            continue
+          }
+        }
+        word.WriteRune(runes[i])
+      }
+      if i >= len(runes) {
+        return nil, errors.New("Unterminated double quote in command: " + command)
+      }
+
+    // Unquoted whitespace ends the word
+    case ' ', '\t', '\n':
+      if inWord {
+        words = append(words, word.String())
+        word.Reset()
+        inWord = false
+      }
+
+    default:
+      inWord = true
+      word.WriteRune(runes[i])
+    }
+  }
+  if inWord {
+    words = append(words, word.String())
+  }
+
+  return words, nil
+}
+
+// shellQuote - Quote a word so a POSIX shell reads it back unchanged
+func shellQuote(word string) (string) {
+
+  return "'" + strings.Replace(word, "'", "'\\''", -1) + "'"
+}
+
+// checkCommand - Make sure the shell, or the command itself when no shell is used, can be split into words
+func (j *JobConfig) checkCommand() (error) {
+
+  if j.Shell != "" {
+    shellWords, err := SplitCommandWords(j.Shell)
+    if err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] has an unparsable shell: " + err.Error())
+    }
+    if len(shellWords) == 0 {
+      return errors.New("Config error: Job [" + j.Label + "] has an empty shell")
+    }
+    return nil
+  }
+
+  if _, err := SplitCommandWords(j.Command); err != nil {
+    return errors.New("Config error: Job [" + j.Label + "] has an unparsable command: " + err.Error())
+  }
+
+  return nil
+}
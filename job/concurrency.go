@@ -0,0 +1,256 @@
+package job
+
+import (
+  "errors"
+  "sort"
+  "strconv"
+  "time"
+)
+
+const (
+  LIMITQUEUE = "queue"
+  LIMITSKIP = "skip"
+  LIMITREPLACE = "replace"
+)
+
+const (
+  LIMITJOB = "job"
+  LIMITGROUP = "group"
+  LIMITDAEMON = "daemon"
+)
+
+// checkConcurrency - Sanity checks on the concurrency limit and policy
+func (j *JobConfig) checkConcurrency() (error) {
+
+  if j.MaxConcurrent < 0 {
+    return errors.New("Config error: Job [" + j.Label + "] maxConcurrent cannot be negative: " + strconv.Itoa(j.MaxConcurrent))
+  }
+  if j.ConcurrencyPolicy != "" && j.ConcurrencyPolicy != LIMITQUEUE && j.ConcurrencyPolicy != LIMITSKIP && j.ConcurrencyPolicy != LIMITREPLACE {
+    return errors.New("Config error: Job [" + j.Label + "] concurrencyPolicy must be one of 'queue', 'skip' or 'replace'")
+  }
+
+  return nil
+}
+
+// JobLimit - Most runs of the job at once, 0 for unlimited.  Locking is a limit of one
+func (j *JobConfig) JobLimit() (int) {
+
+  if j.MaxConcurrent > 0 {
+    return j.MaxConcurrent
+  }
+  if j.Locking == true {
+    return 1
+  }
+
+  return 0
+}
+
+// LimitPolicy - What happens to a run that hits the passed limit.  Without a ConcurrencyPolicy the job's own limit
+//  skips, as Locking always has, and group and daemon limits queue, as groups always have
+func (j *JobConfig) LimitPolicy(limit string) (string) {
+
+  if j.ConcurrencyPolicy != "" {
+    return j.ConcurrencyPolicy
+  }
+  if limit == LIMITJOB {
+    return LIMITSKIP
+  }
+
+  return LIMITQUEUE
+}
+
+// CheckLimits - The first limit starting the run would exceed, checking the job's, then its group's, then the daemon's.
+//  Also returns the token of the oldest run counted against that limit.  Limits of 0 are unlimited and an empty limit
+//  means there is room
+func (t *RunningJobTracker) CheckLimits(newJob RunningJob, groupLimit int, daemonLimit int) (string, string) {
+
+  t.Sync.RLock()
+  defer t.Sync.RUnlock()
+
+  return t.fullLimit(newJob, groupLimit, daemonLimit)
+}
+
+// AddWithinLimits - Track the run if it fits under every limit.  Checking and adding happen under one lock so the
+//  scheduling loop and the API can't both take the last slot.  Runs already queued are let in first, so runs of a job
+//  start in the order they came.  A run that doesn't fit is tracked as queued unless its policy for the full limit is
+//  skip.  Returns the full limit and its oldest run like CheckLimits
+func (t *RunningJobTracker) AddWithinLimits(newJob *RunningJob, schedule JobSchedule, daemonLimit int) (string, string) {
+
+  t.Sync.Lock()
+  defer t.Sync.Unlock()
+
+  t.admitQueued(schedule, daemonLimit)
+  group, _ := schedule.GetGroup(newJob.Config.GroupName)
+  limit, oldestToken := t.fullLimit(*newJob, group.MaxConcurrent, daemonLimit)
+  if limit != "" && newJob.Config.LimitPolicy(limit) == LIMITSKIP {
+    return limit, oldestToken
+  }
+  if limit != "" {
+    newJob.Queued = true
+    newJob.QueuedAt = time.Now()
+    newJob.StartTime = time.Time{}
+  }
+  t.Jobs[newJob.Token] = *newJob
+
+  return limit, oldestToken
+}
+
+// Remove - Stop tracking a finished run and start any queued runs its slot makes room for.  Returns false if the run
+//  wasn't tracked
+func (t *RunningJobTracker) Remove(runToken string, schedule JobSchedule, daemonLimit int) (bool) {
+
+  t.Sync.Lock()
+  defer t.Sync.Unlock()
+
+  _, tracked := t.Jobs[runToken]
+  delete(t.Jobs, runToken)
+  t.admitQueued(schedule, daemonLimit)
+
+  return tracked
+}
+
+// AdmitQueued - Start any queued runs that fit under the schedule's limits, such as after the limits were raised
+func (t *RunningJobTracker) AdmitQueued(schedule JobSchedule, daemonLimit int) {
+
+  t.Sync.Lock()
+  defer t.Sync.Unlock()
+
+  t.admitQueued(schedule, daemonLimit)
+}
+
+// admitQueued - Let queued runs start, oldest first, while they fit under their limits.  Once a run of a job has to
+//  keep waiting so do the runs of that job queued after it.  For callers already holding the lock
+func (t *RunningJobTracker) admitQueued(schedule JobSchedule, daemonLimit int) {
+
+  var queuedJobs []RunningJob
+  for _, tracked := range t.Jobs {
+    if tracked.Queued == true {
+      queuedJobs = append(queuedJobs, tracked)
+    }
+  }
+  sort.Slice(queuedJobs, func(i, j int) bool {
+    if queuedJobs[i].QueuedAt.Equal(queuedJobs[j].QueuedAt) {
+      return queuedJobs[i].Token < queuedJobs[j].Token
+    }
+    return queuedJobs[i].QueuedAt.Before(queuedJobs[j].QueuedAt)
+  })
+
+  stillWaiting := make(map[string]bool)
+  for _, queuedJob := range queuedJobs {
+    if stillWaiting[queuedJob.Config.Label] == true {
+      continue
+    }
+    group, _ := schedule.GetGroup(queuedJob.Config.GroupName)
+    if limit, _ := t.fullLimit(queuedJob, group.MaxConcurrent, daemonLimit); limit != "" {
+      stillWaiting[queuedJob.Config.Label] = true
+      continue
+    }
+    queuedJob.Queued = false
+    queuedJob.StartTime = time.Now()
+    t.Jobs[queuedJob.Token] = queuedJob
+    if queuedJob.Admitted != nil {
+      close(queuedJob.Admitted)
+    }
+  }
+}
+
+// CountRunning - Number of tracked runs that have started, leaving out queued runs
+func (t *RunningJobTracker) CountRunning() (int) {
+
+  t.Sync.RLock()
+  defer t.Sync.RUnlock()
+
+  count := 0
+  for _, tracked := range t.Jobs {
+    if tracked.Queued == false {
+      count++
+    }
+  }
+
+  return count
+}
+
+// WaitForRoom - Hold a queued run until the tracker lets it start, answering stop requests in the meantime.  Returns
+//  false if the run was stopped before it started
+func (r *RunningJob) WaitForRoom() (bool) {
+
+  for {
+    select {
+    case <-r.Admitted:
+      return true
+    case comm := <-r.Channel:
+      if comm.Signal == "stop process" {
+        r.Channel <- ChanComm{Signal: "success"}
+        return false
+      }
+      r.Channel <- ChanComm{Error: errors.New("unknown command")}
+    }
+  }
+}
+
+// fullLimit - CheckLimits for callers already holding the lock
+func (t *RunningJobTracker) fullLimit(newJob RunningJob, groupLimit int, daemonLimit int) (string, string) {
+
+  var jobOldest, groupOldest, daemonOldest RunningJob
+  jobCount, groupCount, daemonCount := 0, 0, 0
+  for _, running := range t.Jobs {
+
+    // Queued runs don't hold a slot until they start
+    if running.Queued == true {
+      continue
+    }
+    if running.Config.Label == newJob.Config.Label {
+      jobCount++
+      if jobOldest.Token == "" || running.StartTime.Before(jobOldest.StartTime) {
+        jobOldest = running
+      }
+    }
+    if newJob.Config.GroupName != "" && running.Config.GroupName == newJob.Config.GroupName {
+      groupCount++
+      if groupOldest.Token == "" || running.StartTime.Before(groupOldest.StartTime) {
+        groupOldest = running
+      }
+    }
+    daemonCount++
+    if daemonOldest.Token == "" || running.StartTime.Before(daemonOldest.StartTime) {
+      daemonOldest = running
+    }
+  }
+
+  jobLimit := newJob.Config.JobLimit()
+  if jobLimit > 0 && jobCount >= jobLimit {
+    return LIMITJOB, jobOldest.Token
+  }
+  if groupLimit > 0 && groupCount >= groupLimit {
+    return LIMITGROUP, groupOldest.Token
+  }
+  if daemonLimit > 0 && daemonCount >= daemonLimit {
+    return LIMITDAEMON, daemonOldest.Token
+  }
+
+  return "", ""
+}
+
+// StopRun - Ask a tracked run to stop the way a stop request from the API would.  Queued runs are dropped before they
+//  start.  Runs that finish before answering count as stopped
+func (t *RunningJobTracker) StopRun(runToken string) (error) {
+
+  t.Sync.RLock()
+  running, exists := t.Jobs[runToken]
+  t.Sync.RUnlock()
+  if exists == false {
+    return errors.New("Cannot find running job with token: " + runToken)
+  }
+
+  select {
+  case running.Channel <- ChanComm{Signal: "stop process"}:
+  case <-running.Done:
+    return nil
+  }
+  select {
+  case reply := <-running.Channel:
+    return reply.Error
+  case <-running.Done:
+    return nil
+  }
+}
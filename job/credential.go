@@ -0,0 +1,112 @@
+package job
+
+import (
+  "errors"
+  "os"
+  "os/user"
+  "strconv"
+  "syscall"
+)
+
+// checkCredential - Make sure the job's user and group exist.  Warn if the daemon lacks the privileges to use them
+func (j *JobConfig) checkCredential() (error) {
+
+  if j.User == "" && j.Group == "" {
+    return nil
+  }
+
+  runAs, err := j.RunAsUser()
+  if err != nil {
+    return errors.New("Config error: Job [" + j.Label + "] runs as unknown user: " + j.User)
+  }
+  if j.Group != "" {
+    if _, err := lookupGroup(j.Group); err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] runs as unknown group: " + j.Group)
+    }
+  }
+
+  if os.Geteuid() != 0 && runAs.Uid != strconv.Itoa(os.Geteuid()) {
+    j.Logger().Warn("[" + j.Label + "] runs as [" + runAs.Username + "] but the daemon is not running as root")
+  }
+
+  return nil
+}
+
+// RunAsUser - The user the job runs as.  Jobs without a User run as the daemon's user
+func (j *JobConfig) RunAsUser() (*user.User, error) {
+
+  if j.User == "" {
+    return user.Current()
+  }
+
+  return lookupUser(j.User)
+}
+
+// credential - Process credentials for the job's User and Group, and the HOME, USER and LOGNAME variables cron sets
+//  for the user.  Both are nil when the job runs as the daemon
+func (j *JobConfig) credential() (*syscall.Credential, []string, error) {
+
+  if j.User == "" && j.Group == "" {
+    return nil, nil, nil
+  }
+
+  runAs, err := j.RunAsUser()
+  if err != nil {
+    return nil, nil, err
+  }
+  uid, err := strconv.ParseUint(runAs.Uid, 10, 32)
+  if err != nil {
+    return nil, nil, err
+  }
+  gidStr := runAs.Gid
+  if j.Group != "" {
+    runAsGroup, err := lookupGroup(j.Group)
+    if err != nil {
+      return nil, nil, err
+    }
+    gidStr = runAsGroup.Gid
+  }
+  gid, err := strconv.ParseUint(gidStr, 10, 32)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  // Carry the user's supplementary groups like a login would
+  credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+  groupIds, err := runAs.GroupIds()
+  if err != nil {
+    j.Logger().Warn("[" + j.Label + "] could not read supplementary groups of [" + runAs.Username + "]: " + err.Error())
+  }
+  for _, groupIdStr := range groupIds {
+    if groupId, err := strconv.ParseUint(groupIdStr, 10, 32); err == nil {
+      credential.Groups = append(credential.Groups, uint32(groupId))
+    }
+  }
+
+  var loginEnv []string
+  if j.User != "" {
+    loginEnv = []string{"HOME=" + runAs.HomeDir, "USER=" + runAs.Username, "LOGNAME=" + runAs.Username}
+  }
+
+  return credential, loginEnv, nil
+}
+
+// lookupUser - Resolve a user name or numeric uid
+func lookupUser(name string) (*user.User, error) {
+
+  if _, err := strconv.Atoi(name); err == nil {
+    return user.LookupId(name)
+  }
+
+  return user.Lookup(name)
+}
+
+// lookupGroup - Resolve a group name or numeric gid
+func lookupGroup(name string) (*user.Group, error) {
+
+  if _, err := strconv.Atoi(name); err == nil {
+    return user.LookupGroupId(name)
+  }
+
+  return user.LookupGroup(name)
+}
@@ -0,0 +1,167 @@
+package job
+
+import (
+  "errors"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// JobDependency - Upstream job that must complete before the dependant job is run
+type JobDependency struct {
+  Label       string            // Label of the upstream job
+  ReturnCodes []int             // Accepted return codes of the upstream job.  Defaults to 0
+  MaxAge      string            // Duration string (ex '12h') the upstream completion stays valid.  Empty never expires
+}
+
+// checkDependencies - Make sure every dependency points to a known job and that the graph has no cycles
+func (h *JobSchedule) checkDependencies(labelToIndex map[string]int) (error) {
+
+  for jobIndex, _ := range h.Job {
+    for _, dependency := range h.Job[jobIndex].DependsOn {
+      if _, exists := labelToIndex[dependency.Label]; exists == false {
+        return errors.New("Config error: Job [" + h.Job[jobIndex].Label + "] depends on unknown job [" + dependency.Label + "]")
+      }
+      if dependency.MaxAge != "" {
+        if _, err := time.ParseDuration(dependency.MaxAge); err != nil {
+          return errors.New("Config error: Job [" + h.Job[jobIndex].Label + "] has an unparsable maxAge: " + dependency.MaxAge)
+        }
+      }
+    }
+  }
+
+  // Depth first search keeping track of the jobs on the current path
+  const (
+    unvisited = iota
+    visiting
+    visited
+  )
+  state := make([]int, len(h.Job))
+  var path []string
+  var visit func(jobIndex int) (error)
+  visit = func(jobIndex int) (error) {
+    state[jobIndex] = visiting
+    path = append(path, h.Job[jobIndex].Label)
+    for _, dependency := range h.Job[jobIndex].DependsOn {
+      upstreamIndex := labelToIndex[dependency.Label]
+      switch state[upstreamIndex] {
+      case visiting:
+        return errors.New("Config error: Dependency cycle detected: " + strings.Join(append(path, dependency.Label), " -> "))
+      case unvisited:
+        if err := visit(upstreamIndex); err != nil {
+          return err
+        }
+      }
+    }
+    path = path[:len(path) - 1]
+    state[jobIndex] = visited
+    return nil
+  }
+
+  for jobIndex, _ := range h.Job {
+    if state[jobIndex] == unvisited {
+      if err := visit(jobIndex); err != nil {
+        return err
+      }
+    }
+  }
+
+  return nil
+}
+
+// GetDownstreamJobs - Find the indexes of every job that depends on the passed label
+func (h *JobSchedule) GetDownstreamJobs(label string) ([]int) {
+
+  var downstream []int
+  for jobIndex, _ := range h.Job {
+    if h.Job[jobIndex].DependsOnLabel(label) {
+      downstream = append(downstream, jobIndex)
+    }
+  }
+
+  return downstream
+}
+
+// DependsOnLabel - Whether the job has the passed label as an upstream dependency
+func (j *JobConfig) DependsOnLabel(label string) (bool) {
+
+  for _, dependency := range j.DependsOn {
+    if dependency.Label == label {
+      return true
+    }
+  }
+
+  return false
+}
+
+// DependenciesMet - Whether every upstream job has completed with an accepted return code since the job was last
+//  triggered and within each dependency's maxAge
+func (j *JobConfig) DependenciesMet(lastResults map[string]JobResult, lastTriggered time.Time, currentTime time.Time) (bool) {
+
+  for _, dependency := range j.DependsOn {
+    result, exists := lastResults[dependency.Label]
+    if exists == false {
+      return false
+    }
+    if result.EndTime.Before(lastTriggered) {
+      return false
+    }
+    if dependency.AcceptsReturnCode(result.ExitCode) == false {
+      return false
+    }
+    if dependency.MaxAge != "" {
+      maxAge, err := time.ParseDuration(dependency.MaxAge)
+      if err != nil || currentTime.Sub(result.EndTime) > maxAge {
+        return false
+      }
+    }
+  }
+
+  return true
+}
+
+// AcceptsReturnCode - Whether the return code of the upstream job satisfies the dependency
+func (d *JobDependency) AcceptsReturnCode(returnCode int) (bool) {
+
+  if len(d.ReturnCodes) == 0 {
+    return returnCode == 0
+  }
+  for _, acceptedCode := range d.ReturnCodes {
+    if acceptedCode == returnCode {
+      return true
+    }
+  }
+
+  return false
+}
+
+// ParseDependencyString - Translate the API notation 'label:code,code:maxAge' into a JobDependency.
+//  Return codes and maxAge are optional ('label', 'label:0,3', 'label::12h')
+func ParseDependencyString(rawStr string) (JobDependency, error) {
+
+  var dependency JobDependency
+  chunks := strings.Split(rawStr, ":")
+  if len(chunks) > 3 || chunks[0] == "" {
+    return JobDependency{}, errors.New("Cannot parse dependency: " + rawStr)
+  }
+  dependency.Label = chunks[0]
+
+  if len(chunks) > 1 && chunks[1] != "" {
+    for _, codeStr := range strings.Split(chunks[1], ",") {
+      code, err := strconv.Atoi(codeStr)
+      if err != nil {
+        return JobDependency{}, errors.New("Cannot parse return code [" + codeStr + "] of dependency: " + rawStr)
+      }
+      dependency.ReturnCodes = append(dependency.ReturnCodes, code)
+    }
+  }
+
+  if len(chunks) > 2 && chunks[2] != "" {
+    if _, err := time.ParseDuration(chunks[2]); err != nil {
+      return JobDependency{}, errors.New("Cannot parse maxAge [" + chunks[2] + "] of dependency: " + rawStr)
+    }
+    dependency.MaxAge = chunks[2]
+  }
+
+  return dependency, nil
+}
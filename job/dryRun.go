@@ -0,0 +1,135 @@
+package job
+
+import (
+  "bytes"
+  "errors"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "strings"
+  "syscall"
+  "time"
+)
+
+// dryRunOutputLimit - Bytes of each output stream kept from a dry run
+const dryRunOutputLimit = 1024 * 1024
+
+// DryRunResult - Outcome of testing a job outside of the scheduler.  Is JSON friendly for API use
+type DryRunResult struct {
+  Label      string
+  Executable string
+  Args       []string
+  Executed   bool
+  ExitCode   int
+  Signal     string
+  TimedOut   bool
+  Duration   time.Duration
+  StdOut     string
+  StdErr     string
+  Error      string
+}
+
+// limitedBuffer - Keep the first limit bytes written and quietly discard the rest
+type limitedBuffer struct {
+  bytes.Buffer
+  limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+
+  if room := b.limit - b.Len(); room > 0 {
+    if len(p) > room {
+      b.Buffer.Write(p[:room])
+    } else {
+      b.Buffer.Write(p)
+    }
+  }
+
+  return len(p), nil
+}
+
+// DryRun - Validate a job and, when execute is set, run it once under a timeout.  The run gets a scratch working
+//  directory in place of any WorkingDir, no stdin and its own process group.  It is not tracked, logged to disk or
+//  recorded in the run history
+func (j *JobConfig) DryRun(extraArgs []string, extraEnv []string, execute bool, timeout time.Duration) (DryRunResult, error) {
+
+  result := DryRunResult{Label: j.Label, ExitCode: -1}
+
+  // The schedule must be valid even if the command is
+  if err := j.ParseScheduleIntoFilters(true); err != nil {
+    result.Error = err.Error()
+    return result, err
+  }
+
+  testJob := RunningJob{Config: *j, ExtraArgs: extraArgs, ExtraEnv: extraEnv}
+  if strings.TrimSpace(j.Command) == "" {
+    err := errors.New("Missing exec command in job configuration")
+    result.Error = err.Error()
+    return result, err
+  }
+  cmd, err := testJob.buildCommand()
+  if err != nil {
+    result.Error = err.Error()
+    return result, err
+  }
+  result.Executable = cmd.Path
+  result.Args = cmd.Args[1:]
+
+  // Make sure the executable can be found before anything is run
+  if _, err := exec.LookPath(cmd.Path); err != nil {
+    result.Error = err.Error()
+    return result, err
+  }
+  if execute == false {
+    return result, nil
+  }
+
+  // Keep the run away from the daemon's working directory
+  sandboxDir, err := ioutil.TempDir("", "omicrond-dryrun")
+  if err != nil {
+    result.Error = err.Error()
+    return result, err
+  }
+  defer os.RemoveAll(sandboxDir)
+  cmd.Dir = sandboxDir
+
+  // The job's user needs to be able to work in the scratch directory
+  if credential := cmd.SysProcAttr.Credential; credential != nil {
+    if err := os.Chown(sandboxDir, int(credential.Uid), int(credential.Gid)); err != nil {
+      result.Error = err.Error()
+      return result, err
+    }
+  }
+
+  stdOut := &limitedBuffer{limit: dryRunOutputLimit}
+  stdErr := &limitedBuffer{limit: dryRunOutputLimit}
+  cmd.Stdout = stdOut
+  cmd.Stderr = stdErr
+
+  startTime := time.Now()
+  if err := j.startCommand(cmd); err != nil {
+    result.Error = err.Error()
+    return result, err
+  }
+  result.Executed = true
+
+  // Kill the whole process group if the command outlives its timeout
+  done := make(chan error, 1)
+  go func() {
+    done <- cmd.Wait()
+  }()
+  select {
+  case <-done:
+  case <-time.After(timeout):
+    result.TimedOut = true
+    syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+    <-done
+  }
+
+  result.Duration = time.Now().Sub(startTime)
+  result.ExitCode, result.Signal = determineExitStatus(cmd)
+  result.StdOut = stdOut.String()
+  result.StdErr = stdErr.String()
+
+  return result, nil
+}
@@ -0,0 +1,157 @@
+package job
+
+import (
+  "bufio"
+  "errors"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "syscall"
+)
+
+// umaskSync - The umask belongs to the whole daemon, so only one command at a time may change it while starting
+var umaskSync sync.Mutex
+
+// envLineRegex - 'NAME=value' with optional spaces around the '=' and an optional leading 'export'
+var envLineRegex = regexp.MustCompile("^\\s*(export\\s+)?([A-Za-z_][A-Za-z0-9_]*)\\s*=\\s*(.*?)\\s*$")
+
+// checkEnvironment - Make sure the env file can be read, the working directory is absolute and the umask is octal
+func (j *JobConfig) checkEnvironment() (error) {
+
+  for name, _ := range j.Env {
+    if envLineRegex.MatchString(name + "=") == false {
+      return errors.New("Config error: Job [" + j.Label + "] has an invalid environment variable name: " + name)
+    }
+  }
+  if j.EnvFile != "" {
+    if _, err := ReadEnvFile(j.EnvFile); err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] has an unreadable env file: " + err.Error())
+    }
+  }
+  if j.WorkingDir != "" && filepath.IsAbs(j.WorkingDir) == false {
+    return errors.New("Config error: Job [" + j.Label + "] working directory must be an absolute path: " + j.WorkingDir)
+  }
+  if j.Umask != "" {
+    if _, err := parseUmask(j.Umask); err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] " + err.Error())
+    }
+  }
+
+  return nil
+}
+
+// environment - Build the environment of the command.  Starts from the daemon's environment, or nothing with
+//  ClearEnv, then layers the login variables, the env file, Env and the one-off extraEnv in that order.  Nil when
+//  the command should simply inherit the daemon's environment
+func (j *JobConfig) environment(loginEnv []string, extraEnv []string) ([]string, error) {
+
+  if j.ClearEnv == false && len(loginEnv) == 0 && j.EnvFile == "" && len(j.Env) == 0 && len(extraEnv) == 0 {
+    return nil, nil
+  }
+
+  env := []string{}
+  if j.ClearEnv == false {
+    env = append(env, os.Environ()...)
+  }
+  env = append(env, loginEnv...)
+
+  if j.EnvFile != "" {
+    fileEnv, err := ReadEnvFile(j.EnvFile)
+    if err != nil {
+      return nil, err
+    }
+    env = append(env, fileEnv...)
+  }
+
+  // Sort so the order, and which duplicate wins, never changes between runs
+  var names []string
+  for name, _ := range j.Env {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    env = append(env, name + "=" + j.Env[name])
+  }
+
+  return append(env, extraEnv...), nil
+}
+
+// ParseEnvLine - Read a 'NAME=value' line of a crontab or env file.  Quotes around the value are removed.
+//  ok is false for lines that aren't variable assignments
+func ParseEnvLine(line string) (string, string, bool) {
+
+  matches := envLineRegex.FindStringSubmatch(line)
+  if matches == nil {
+    return "", "", false
+  }
+  value := matches[3]
+  if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value) - 1] == value[0] {
+    value = value[1:len(value) - 1]
+  }
+
+  return matches[2], value, true
+}
+
+// ReadEnvFile - Read the 'NAME=value' lines of an env file.  Blank lines and '#' comments are skipped
+func ReadEnvFile(envFilePath string) ([]string, error) {
+
+  envFile, err := os.Open(envFilePath)
+  if err != nil {
+    return nil, err
+  }
+  defer envFile.Close()
+
+  var env []string
+  scanner := bufio.NewScanner(envFile)
+  lineNumber := 0
+  for scanner.Scan() {
+    lineNumber++
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    name, value, ok := ParseEnvLine(line)
+    if ok == false {
+      return nil, errors.New("Cannot parse line " + strconv.Itoa(lineNumber) + " of " + envFilePath)
+    }
+    env = append(env, name + "=" + value)
+  }
+
+  return env, scanner.Err()
+}
+
+// parseUmask - Convert an octal umask string (ex '022') into its value
+func parseUmask(umaskStr string) (int, error) {
+
+  umask, err := strconv.ParseUint(umaskStr, 8, 32)
+  if err != nil || umask > 0777 {
+    return 0, errors.New("umask must be an octal number between 000 and 777: " + umaskStr)
+  }
+
+  return int(umask), nil
+}
+
+// startCommand - Start the command with the job's umask.  The command inherits the umask at the moment it is started,
+//  so the daemon's umask is swapped out only for as long as that takes
+func (j *JobConfig) startCommand(cmd *exec.Cmd) (error) {
+
+  if j.Umask == "" {
+    return cmd.Start()
+  }
+  umask, err := parseUmask(j.Umask)
+  if err != nil {
+    return err
+  }
+
+  umaskSync.Lock()
+  defer umaskSync.Unlock()
+  previousUmask := syscall.Umask(umask)
+  defer syscall.Umask(previousUmask)
+
+  return cmd.Start()
+}
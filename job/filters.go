@@ -8,12 +8,31 @@ import (
   "strconv"
 )
 
-// ParseDayOfWeekIntoFilter - Translate schedule notation of a day of the week (0: Sun - 6: Sat)
+const (
+  SCHEDULELEGACY = "legacy"
+  SCHEDULECRON = "cron"
+)
+
+// fieldBounds - Smallest and largest value of each five field schedule field, indexed by MINUTE through DAYOFWEEK
+var fieldBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// monthNames - Names accepted in place of numbers in the month field
+var monthNames = map[string]int{
+  "JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+  "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+// dayOfWeekNames - Names accepted in place of numbers in the day of week field
+var dayOfWeekNames = map[string]int{
+  "SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// ParseDayOfWeekIntoFilter - Translate schedule notation of a day of the week (0: Sun - 6: Sat, 7: Sun)
 //  into a function that when called with a datetime will, tell you if the date is on that day
 func ParseDayOfWeekIntoFilter(rawStr string) (func(testTime time.Time) (bool), error) {
 
   // Run regex parsers against schedule string notation
-  WeekDaysIntMap, _, err := parseScheduleStringToIntMap(rawStr)
+  WeekDaysIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr, 0, 7, dayOfWeekNames)
   if err != nil {
     return nil, err
   }
@@ -21,7 +40,7 @@ func ParseDayOfWeekIntoFilter(rawStr string) (func(testTime time.Time) (bool), e
   // Convert the slice of numbered weekdays to their proper names
   scheduledWeekDaysStringMap := make(map[string]bool)
   for intValue, _ := range WeekDaysIntMap {
-    dayName, err := intToDayOfWeek(intValue)
+    dayName, err := intToDayOfWeek(intValue % 7)
     if err != nil {
       return nil, err
     }
@@ -31,7 +50,7 @@ func ParseDayOfWeekIntoFilter(rawStr string) (func(testTime time.Time) (bool), e
   // Add the filter using the slice of allowed weekdays
   filterFunc := func(testTime time.Time) (bool) {
     _, isScheduled := scheduledWeekDaysStringMap[testTime.Weekday().String()]
-    if isScheduled == true {
+    if isScheduled == true || (int(testTime.Weekday()) % intervalModulo == 0 && intervalModulo > 1) {
       return true
     }
     return false
@@ -45,7 +64,7 @@ func ParseDayOfWeekIntoFilter(rawStr string) (func(testTime time.Time) (bool), e
 func ParseMonthIntoFilter(rawStr string) (func(testTime time.Time) (bool), error) {
 
   // Run regex parsers against schedule string notation
-  scheduledMonthsIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr)
+  scheduledMonthsIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr, 1, 12, monthNames)
   if err != nil {
     return nil, err
   }
@@ -61,12 +80,12 @@ func ParseMonthIntoFilter(rawStr string) (func(testTime time.Time) (bool), error
   return filterFunc, err
 }
 
-// ParseDayOfMonthIntoFilter - Translate schedule notation of a day in a month (1 - 32)
+// ParseDayOfMonthIntoFilter - Translate schedule notation of a day in a month (1 - 31)
 //  into a function that when called with a datetime, will tell you if the date is on that day of the month
 func ParseDayOfMonthIntoFilter(rawStr string) (func(testTime time.Time) (bool), error) {
 
   // Run regex parsers against schedule string notation
-  scheduledDaysOfMonthsIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr)
+  scheduledDaysOfMonthsIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr, 1, 31, nil)
   if err != nil {
     return nil, err
   }
@@ -87,7 +106,7 @@ func ParseDayOfMonthIntoFilter(rawStr string) (func(testTime time.Time) (bool),
 func ParseHourIntoFilter(rawStr string) (func(testTime time.Time) (bool), error) {
 
   // Run regex parsers against schedule string notation
-  scheduledHoursIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr)
+  scheduledHoursIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr, 0, 23, nil)
   if err != nil {
     return nil, err
   }
@@ -108,7 +127,7 @@ func ParseHourIntoFilter(rawStr string) (func(testTime time.Time) (bool), error)
 func ParseMinuteIntoFilter(rawStr string) (func(testTime time.Time) (bool), error) {
 
   // Run regex parsers against schedule string notation
-  scheduledMinutesIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr)
+  scheduledMinutesIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr, 0, 59, nil)
   if err != nil {
     return nil, err
   }
@@ -124,68 +143,166 @@ func ParseMinuteIntoFilter(rawStr string) (func(testTime time.Time) (bool), erro
   return filterFunc, err
 }
 
+// ParseSecondIntoFilter - Translate schedule notation of a second in a minute (0 - 59)
+//  into a function that when called with a datetime, will tell you if the time is currently in that second
+func ParseSecondIntoFilter(rawStr string) (func(testTime time.Time) (bool), error) {
+
+  // Run regex parsers against schedule string notation
+  scheduledSecondsIntMap, intervalModulo, err := parseScheduleStringToIntMap(rawStr, 0, 59, nil)
+  if err != nil {
+    return nil, err
+  }
+  // Add the filter using the slice of ints
+  filterFunc := func(testTime time.Time) (bool) {
+    _, isScheduled := scheduledSecondsIntMap[testTime.Second()]
+    if isScheduled == true || (testTime.Second() % intervalModulo == 0 && intervalModulo > 1) {
+      return true
+    }
+    return false
+  }
+
+  return filterFunc, err
+}
+
 // parseScheduleStringToIntMap - Parse a schedule notation string into an integer map representing points
 //  of time within that scope (such as 30 being the 30th minute in an hour) and an integer modulo (ex 5) which represents
-//  the shorthand schedule notation for an interval (ex '*/5')
-func parseScheduleStringToIntMap(rawStr string) (map[int]bool, int, error) {
+//  the shorthand schedule notation for an interval (ex '*/5').  Follows the crontab(5) grammar: lists, ranges,
+//  stepped ranges (ex '1-30/5' or '10/15'), and names (ex 'JAN' or 'mon-fri') when the field has them.
+//  Values must fall between minValue and maxValue.
+func parseScheduleStringToIntMap(rawStr string, minValue int, maxValue int, names map[string]int) (map[int]bool, int, error) {
 
   var err error
-  var elementStrNumSlice []string // Slice of stringified integers that need to be converted into ints
-  elementIntMap := make(map[int]bool) // Map of integers after being Atoi-ed from elementStrNumSlice
+  var elementStrNumSlice []string // Slice of list elements that need to be converted into ints
+  elementIntMap := make(map[int]bool) // Map of integers after being converted from elementStrNumSlice
   intervalModulo := 1 // Interval operand.  Default 1 so that it will always equal true if checked
+  elementRegex := regexp.MustCompile("^(" + regexp.QuoteMeta("*") + "|[0-9A-Za-z]+(-[0-9A-Za-z]+)?)(/([0-9]+))?$")
 
   elementStrNumSlice = strings.Split(rawStr, ",")
   for _, elementStrNum := range elementStrNumSlice {
 
-    if proceed, _ := regexp.MatchString("^" + regexp.QuoteMeta("*/") + "[0-9]+$", elementStrNum); proceed == true {
-      re := regexp.MustCompile("^" + regexp.QuoteMeta("*/") + "([0-9]+)$")
-      matches := re.FindStringSubmatch(elementStrNum)
-      intervalModulo, err = strconv.Atoi(matches[1])
+    matches := elementRegex.FindStringSubmatch(elementStrNum)
+    if matches == nil {
+      return nil, intervalModulo, errors.New("Could not parse [" + elementStrNum + "] of " + rawStr)
+    }
+    rangeStr := matches[1]
 
-      if err != nil {
-        return nil, intervalModulo, err
+    // Split off the step of a stepped element
+    step := 0
+    if matches[4] != "" {
+      step, err = strconv.Atoi(matches[4])
+      if err != nil || step < 1 {
+        return nil, intervalModulo, errors.New("Could not parse step of [" + elementStrNum + "] of " + rawStr)
       }
-      // Create a (single element) slice of a single implicit time scope
-    } else if proceed, _ := regexp.MatchString("^[0-9]+$", elementStrNum); proceed == true {
+    }
 
-      intValue, err := strconv.Atoi(elementStrNum)
-      if err != nil {
-        return nil, intervalModulo, err
-      }
-      elementIntMap[intValue] = true
-    } else if proceed, _ := regexp.MatchString("[0-9]+-[0-9]+", elementStrNum); proceed == true {
-      elementStrNumSlice = strings.Split(elementStrNum, "-")
-      startDay, err := strconv.Atoi(elementStrNumSlice[0])
+    // '*/n' stays an interval of the value itself
+    if rangeStr == "*" && step > 0 {
+      intervalModulo = step
+      continue
+    }
+
+    // Determine the bounds of the element.  A lone value with a step runs to the end of the field
+    var startValue, endValue int
+    if rangeStr == "*" {
+      startValue, endValue = minValue, maxValue
+    } else if rangeChunks := strings.Split(rangeStr, "-"); len(rangeChunks) == 2 {
+      startValue, err = parseScheduleValue(rangeChunks[0], names)
       if err != nil {
         return nil, intervalModulo, err
       }
-      endDay, err := strconv.Atoi(elementStrNumSlice[1])
+      endValue, err = parseScheduleValue(rangeChunks[1], names)
       if err != nil {
         return nil, intervalModulo, err
       }
-      if (startDay < endDay) {
-        for i := startDay; i <= endDay; i++ {
-          elementIntMap[i] = true
-        }
-      } else {
+      if startValue > endValue {
         return nil, intervalModulo, errors.New("Element range implication is not smaller to larger.  ")
       }
-    } else if proceed, _ := regexp.MatchString("^" + regexp.QuoteMeta("*/") + "[0-9]+$", elementStrNum); proceed == true {
-      re := regexp.MustCompile("^" + regexp.QuoteMeta("*/") + "([0-9]+)$")
-      matches := re.FindStringSubmatch(elementStrNum)
-      intervalModulo, err = strconv.Atoi(matches[1])
-
+    } else {
+      startValue, err = parseScheduleValue(rangeStr, names)
       if err != nil {
         return nil, intervalModulo, err
       }
-    } else {
-      return nil, intervalModulo, errors.New("Could not parse [" + elementStrNum + "] of " + rawStr)
+      endValue = startValue
+      if step > 0 {
+        endValue = maxValue
+      }
+    }
+
+    if startValue < minValue || endValue > maxValue {
+      return nil, intervalModulo, errors.New("[" + elementStrNum + "] of " + rawStr + " is outside of " + strconv.Itoa(minValue) + "-" + strconv.Itoa(maxValue))
+    }
+
+    if step == 0 {
+      step = 1
+    }
+    for i := startValue; i <= endValue; i += step {
+      elementIntMap[i] = true
     }
   }
 
   return elementIntMap, intervalModulo, err
 }
 
+// parseScheduleValue - Convert a single schedule value, either a number or one of the field's names, into an int
+func parseScheduleValue(valueStr string, names map[string]int) (int, error) {
+
+  if value, exists := names[strings.ToUpper(valueStr)]; exists == true {
+    return value, nil
+  }
+  value, err := strconv.Atoi(valueStr)
+  if err != nil {
+    return 0, errors.New("Could not parse value [" + valueStr + "]")
+  }
+
+  return value, nil
+}
+
+// anchorIntervals - Rewrite each '*/n' element as 'min-max/n' so the interval counts from the start of the field's
+//  range like cron, rather than matching values evenly divisible by n
+func anchorIntervals(rawStr string, minValue int, maxValue int) (string) {
+
+  elementStrSlice := strings.Split(rawStr, ",")
+  for elementIndex, elementStr := range elementStrSlice {
+    if strings.HasPrefix(elementStr, "*/") {
+      elementStrSlice[elementIndex] = strconv.Itoa(minValue) + "-" + strconv.Itoa(maxValue) + elementStr[1:]
+    }
+  }
+
+  return strings.Join(elementStrSlice, ",")
+}
+
+// hasIntervalStep - Whether any element of the field is a '*/n' interval
+func hasIntervalStep(rawStr string) (bool) {
+
+  for _, elementStr := range strings.Split(rawStr, ",") {
+    if strings.HasPrefix(elementStr, "*/") {
+      return true
+    }
+  }
+
+  return false
+}
+
+// expandScheduleMacro - Translate the crontab(5) '@' shorthands into their five field equivalents.  @reboot has
+//  no equivalent and is handled by the daemon at startup
+func expandScheduleMacro(schedule string) (string, error) {
+
+  switch strings.ToLower(schedule) {
+  case "@yearly", "@annually":
+    return "0 0 1 1 *", nil
+  case "@monthly":
+    return "0 0 1 * *", nil
+  case "@weekly":
+    return "0 0 * * 0", nil
+  case "@daily", "@midnight":
+    return "0 0 * * *", nil
+  case "@hourly":
+    return "0 * * * *", nil
+  default:
+    return "", errors.New("Unknown schedule macro: " + schedule)
+  }
+}
+
 // intToDayOfWeek - Used to convert integer representations of a day of the week into the English standard name
 func intToDayOfWeek(intDay int) (string, error) {
   var err error
@@ -205,6 +322,6 @@ func intToDayOfWeek(intDay int) (string, error) {
   case 6:
     return SATURDAY, err
   default:
-    return "", errors.New("Cannot convert (" + string(intDay) + ") into a weekday")
+    return "", errors.New("Cannot convert (" + strconv.Itoa(intDay) + ") into a weekday")
   }
 }
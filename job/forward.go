@@ -0,0 +1,283 @@
+package job
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "net"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "github.com/Sirupsen/logrus"
+  "github.com/brysearl/omicrond/conf"
+)
+
+const (
+  FORWARDSYSLOG = "syslog"
+  FORWARDJOURNAL = "journal"
+)
+
+// syslogSocket - Datagram socket of the local syslog daemon
+var syslogSocket = "/dev/log"
+
+// journalSocket - Native protocol socket of the systemd journal
+var journalSocket = "/run/systemd/journal/socket"
+
+// syslogFacilities - Facility codes by the names syslog.conf uses
+var syslogFacilities = map[string]int{
+  "kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8, "cron": 9,
+  "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21,
+  "local6": 22, "local7": 23}
+
+// syslogPriorities - Severity codes by the names syslog.conf uses
+var syslogPriorities = map[string]int{
+  "emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7}
+
+// SyslogConfig - Forwarding of a job's output to the local syslog and the systemd journal, one line per message
+type SyslogConfig struct {
+  To             []string        // Where output goes: syslog (/dev/log) and journal (systemd's native socket).  Empty uses the daemon default
+  Identifier     string          // Tag of each message (SYSLOG_IDENTIFIER).  Defaults to the job label
+  Facility       string          // Facility messages are logged under (ex 'local3').  Defaults to cron
+  StdOutPriority string          // Priority of stdout lines.  Defaults to info
+  StdErrPriority string          // Priority of stderr lines.  Defaults to warning
+}
+
+// logEvent - A message bound for syslog and the journal
+type logEvent struct {
+  message    string
+  identifier string
+  facility   int
+  priority   int
+  fields     map[string]string   // Extra journal fields.  Syslog gets them appended to the message
+}
+
+// logForwarder - Connections to the local syslog and journal sockets.  Safe to share between goroutines
+type logForwarder struct {
+  syslog  net.Conn
+  journal net.Conn
+}
+
+// CheckForwardTargets - Make sure every forwarding target is one we know how to reach
+func CheckForwardTargets(targets []string) (error) {
+
+  for _, target := range targets {
+    if target != FORWARDSYSLOG && target != FORWARDJOURNAL {
+      return errors.New("forwarding targets must be syslog or journal: " + target)
+    }
+  }
+
+  return nil
+}
+
+// checkSyslog - Make sure the forwarding targets, facility and priorities are ones syslog knows
+func (j *JobConfig) checkSyslog() (error) {
+
+  if err := CheckForwardTargets(j.Syslog.To); err != nil {
+    return errors.New("Config error: Job [" + j.Label + "] syslog " + err.Error())
+  }
+  if _, exists := syslogFacilities[j.Syslog.Facility]; j.Syslog.Facility != "" && exists == false {
+    return errors.New("Config error: Job [" + j.Label + "] syslog facility is unknown: " + j.Syslog.Facility)
+  }
+  for _, priority := range []string{j.Syslog.StdOutPriority, j.Syslog.StdErrPriority} {
+    if _, exists := syslogPriorities[priority]; priority != "" && exists == false {
+      return errors.New("Config error: Job [" + j.Label + "] syslog priority is unknown: " + priority)
+    }
+  }
+
+  return nil
+}
+
+// openLogForwarder - Connect to each target.  Targets that can't be reached are skipped and reported in the error
+func openLogForwarder(targets []string) (*logForwarder, error) {
+
+  forwarder := &logForwarder{}
+  var failures []string
+  for _, target := range targets {
+    socketPath := syslogSocket
+    if target == FORWARDJOURNAL {
+      socketPath = journalSocket
+    }
+    conn, err := net.Dial("unixgram", socketPath)
+    if err != nil {
+      failures = append(failures, err.Error())
+      continue
+    }
+    if target == FORWARDJOURNAL {
+      forwarder.journal = conn
+    } else {
+      forwarder.syslog = conn
+    }
+  }
+  if len(failures) > 0 {
+    return forwarder, errors.New("Cannot forward logs: " + strings.Join(failures, ", "))
+  }
+
+  return forwarder, nil
+}
+
+// send - Pass the event on to every connected target
+func (f *logForwarder) send(event logEvent) {
+
+  if f == nil {
+    return
+  }
+  if f.syslog != nil {
+    f.syslog.Write(formatSyslog(event, time.Now()))
+  }
+  if f.journal != nil {
+    f.journal.Write(formatJournal(event))
+  }
+}
+
+// Close - Close the connections to every target
+func (f *logForwarder) Close() {
+
+  if f == nil {
+    return
+  }
+  if f.syslog != nil {
+    f.syslog.Close()
+  }
+  if f.journal != nil {
+    f.journal.Close()
+  }
+}
+
+// formatSyslog - Format the event the way the local syslog socket expects: '<PRI>Mmm dd hh:mm:ss tag[pid]: message'
+func formatSyslog(event logEvent, now time.Time) ([]byte) {
+
+  message := event.message
+  keys := make([]string, 0, len(event.fields))
+  for key, _ := range event.fields {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for _, key := range keys {
+    message += " " + strings.ToLower(strings.TrimPrefix(key, "OMICROND_")) + "=" + event.fields[key]
+  }
+
+  return []byte("<" + strconv.Itoa(event.facility * 8 + event.priority) + ">" + now.Format(time.Stamp) + " " +
+    event.identifier + "[" + strconv.Itoa(os.Getpid()) + "]: " + strings.Replace(message, "\n", " ", -1))
+}
+
+// formatJournal - Encode the event in the journal's native protocol.  Values holding a newline are sent as the
+//  field name, a newline, their length as a little endian uint64 and then the value
+func formatJournal(event logEvent) ([]byte) {
+
+  fields := map[string]string{
+    "MESSAGE": event.message,
+    "PRIORITY": strconv.Itoa(event.priority),
+    "SYSLOG_FACILITY": strconv.Itoa(event.facility),
+    "SYSLOG_IDENTIFIER": event.identifier}
+  for key, value := range event.fields {
+    fields[key] = value
+  }
+  keys := make([]string, 0, len(fields))
+  for key, _ := range fields {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+
+  var encoded bytes.Buffer
+  for _, key := range keys {
+    value := fields[key]
+    if strings.Contains(value, "\n") {
+      encoded.WriteString(key + "\n")
+      binary.Write(&encoded, binary.LittleEndian, uint64(len(value)))
+      encoded.WriteString(value + "\n")
+    } else {
+      encoded.WriteString(key + "=" + value + "\n")
+    }
+  }
+
+  return encoded.Bytes()
+}
+
+// forwardTargets - Where the job's output goes, falling back on the daemon's JobLogForward
+func (j *JobConfig) forwardTargets() ([]string) {
+
+  if len(j.Syslog.To) > 0 {
+    return j.Syslog.To
+  }
+
+  return conf.Attr.JobLogForward
+}
+
+// outputEvent - A line of the run's output as a log event, with the job's identifier, facility and stream priority
+func (r *RunningJob) outputEvent(stream string, line string) (logEvent) {
+
+  event := logEvent{message: line, identifier: r.Config.Syslog.Identifier, facility: syslogFacilities["cron"], priority: syslogPriorities["info"]}
+  if event.identifier == "" {
+    event.identifier = r.Config.Label
+  }
+  if facility, exists := syslogFacilities[r.Config.Syslog.Facility]; exists {
+    event.facility = facility
+  }
+  priority := r.Config.Syslog.StdOutPriority
+  if stream == "stderr" {
+    event.priority = syslogPriorities["warning"]
+    priority = r.Config.Syslog.StdErrPriority
+  }
+  if code, exists := syslogPriorities[priority]; exists {
+    event.priority = code
+  }
+  event.fields = map[string]string{"OMICROND_LABEL": r.Config.Label, "OMICROND_TOKEN": r.Token, "OMICROND_STREAM": stream}
+  if r.Config.GroupName != "" {
+    event.fields["OMICROND_GROUP"] = r.Config.GroupName
+  }
+
+  return event
+}
+
+// ForwardHook - logrus hook sending the daemon's own log to syslog and the journal.  Entry fields become journal
+//  fields prefixed with OMICROND_
+type ForwardHook struct {
+  forwarder *logForwarder
+}
+
+// NewForwardHook - Connect a hook to the targets.  Fails if any of them can't be reached
+func NewForwardHook(targets []string) (*ForwardHook, error) {
+
+  if err := CheckForwardTargets(targets); err != nil {
+    return nil, err
+  }
+  forwarder, err := openLogForwarder(targets)
+  if err != nil {
+    forwarder.Close()
+    return nil, err
+  }
+
+  return &ForwardHook{forwarder: forwarder}, nil
+}
+
+// Levels - The hook forwards every level the logger lets through
+func (h *ForwardHook) Levels() ([]logrus.Level) {
+  return logrus.AllLevels
+}
+
+// Fire - Forward the entry with its level mapped onto a syslog priority
+func (h *ForwardHook) Fire(entry *logrus.Entry) (error) {
+
+  event := logEvent{message: entry.Message, identifier: "omicrond", facility: syslogFacilities["daemon"], priority: syslogPriorities["info"]}
+  switch entry.Level {
+  case logrus.PanicLevel, logrus.FatalLevel:
+    event.priority = syslogPriorities["crit"]
+  case logrus.ErrorLevel:
+    event.priority = syslogPriorities["err"]
+  case logrus.WarnLevel:
+    event.priority = syslogPriorities["warning"]
+  case logrus.DebugLevel:
+    event.priority = syslogPriorities["debug"]
+  }
+  event.fields = make(map[string]string)
+  for key, value := range entry.Data {
+    if stringValue, ok := value.(string); ok {
+      event.fields["OMICROND_" + strings.ToUpper(key)] = stringValue
+    }
+  }
+  h.forwarder.send(event)
+
+  return nil
+}
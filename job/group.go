@@ -0,0 +1,114 @@
+package job
+
+import (
+  "errors"
+  "math/rand"
+  "strconv"
+  "time"
+)
+
+const (
+  SPREADEVEN = "even"
+  SPREADRANDOM = "random"
+)
+
+// GroupConfig - Settings shared by every job with the same GroupName
+type GroupConfig struct {
+  Name          string `toml:"name"`           // Matches the GroupName of the jobs in the group
+  StartWindow   string `toml:"start_window"`   // Duration after the scheduled minute the first job may start (ex '0s')
+  StopWindow    string `toml:"stop_window"`    // Duration after the scheduled minute the last job must have started by (ex '10m')
+  MaxConcurrent int    `toml:"max_concurrent"` // Most jobs of the group running at once, however started.  0 is unlimited
+  Spread        string `toml:"spread"`         // How jobs are placed within the window: even (default) or random
+}
+
+// checkGroups - Sanity checks on the group configurations
+func (h *JobSchedule) checkGroups() (error) {
+
+  nameCheck := make(map[string]bool)
+  for groupIndex, _ := range h.Group {
+    group := &h.Group[groupIndex]
+    if group.Name == "" {
+      return errors.New("Config error: Group with an missing/empty name")
+    }
+    if nameCheck[group.Name] == true {
+      return errors.New("Config error: Groups with duplicate names.")
+    }
+    nameCheck[group.Name] = true
+
+    startWindow, stopWindow, err := group.Windows()
+    if err != nil {
+      return err
+    }
+    if startWindow < 0 || stopWindow < startWindow {
+      return errors.New("Config error: Group [" + group.Name + "] stop_window must not be before start_window")
+    }
+    if group.MaxConcurrent < 0 {
+      return errors.New("Config error: Group [" + group.Name + "] max_concurrent cannot be negative: " + strconv.Itoa(group.MaxConcurrent))
+    }
+    if group.Spread != "" && group.Spread != SPREADEVEN && group.Spread != SPREADRANDOM {
+      return errors.New("Config error: Group [" + group.Name + "] spread must be either 'even' or 'random'")
+    }
+  }
+
+  return nil
+}
+
+// GetGroup - Find the configuration of a group using its name
+func (h *JobSchedule) GetGroup(name string) (GroupConfig, bool) {
+
+  if name == "" {
+    return GroupConfig{}, false
+  }
+  for groupIndex, _ := range h.Group {
+    if h.Group[groupIndex].Name == name {
+      return h.Group[groupIndex], true
+    }
+  }
+
+  return GroupConfig{}, false
+}
+
+// Windows - Parse the start and stop windows.  Empty windows are zero
+func (g *GroupConfig) Windows() (time.Duration, time.Duration, error) {
+
+  var startWindow, stopWindow time.Duration
+  var err error
+  if g.StartWindow != "" {
+    startWindow, err = time.ParseDuration(g.StartWindow)
+    if err != nil {
+      return 0, 0, errors.New("Config error: Group [" + g.Name + "] has an unparsable start_window: " + g.StartWindow)
+    }
+  }
+  if g.StopWindow != "" {
+    stopWindow, err = time.ParseDuration(g.StopWindow)
+    if err != nil {
+      return 0, 0, errors.New("Config error: Group [" + g.Name + "] has an unparsable stop_window: " + g.StopWindow)
+    }
+  } else {
+    stopWindow = startWindow
+  }
+
+  return startWindow, stopWindow, nil
+}
+
+// StaggerDelays - Spread jobCount starts of the group across its window.  Delays are measured from the
+//  scheduled minute and returned in job order
+func (g *GroupConfig) StaggerDelays(jobCount int) ([]time.Duration) {
+
+  delays := make([]time.Duration, jobCount)
+  startWindow, stopWindow, err := g.Windows()
+  if err != nil || jobCount == 0 {
+    return delays
+  }
+
+  width := stopWindow - startWindow
+  for jobIndex := 0; jobIndex < jobCount; jobIndex++ {
+    if g.Spread == SPREADRANDOM && width > 0 {
+      delays[jobIndex] = startWindow + time.Duration(rand.Int63n(int64(width)))
+    } else {
+      delays[jobIndex] = startWindow + width * time.Duration(jobIndex) / time.Duration(jobCount)
+    }
+  }
+
+  return delays
+}
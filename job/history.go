@@ -0,0 +1,259 @@
+package job
+
+import (
+  "bufio"
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+  "github.com/Sirupsen/logrus"
+)
+
+const (
+  STATUSSUCCEEDED = "succeeded"
+  STATUSFAILED = "failed"
+  STATUSKILLED = "killed"
+  STATUSTIMEDOUT = "timed out"
+)
+
+// JobResult - The outcome of a completed run.  Used to resolve dependencies and stored in the run history
+type JobResult struct {
+  Token      string
+  Label      string
+  Trigger    string            // What started the run: schedule, dependency, manual, reboot or catch up
+  MissedAt   time.Time         // Occurrence a catch up run made up for
+  StartTime  time.Time
+  EndTime    time.Time
+  ExitCode   int               // Return code of the command.  -1 if it never started or was killed by a signal
+  Signal     string            // Name of the signal that killed the command, if any
+  TimedOut   bool              // The command outlived its Timeout and was terminated
+  Status     string            // Outcome of the run: succeeded, failed, killed or timed out
+  Attempt    int               // Attempt of the run.  Retries are recorded under the same Token
+  Usage      RunUsage          // Resources used by the command and the children it waited on
+  StdOutPath string
+  StdErrPath string
+}
+
+// DetermineStatus - Summarize the outcome of the run from its exit code, signal and timeout
+func (result *JobResult) DetermineStatus() (string) {
+
+  switch {
+  case result.TimedOut:
+    return STATUSTIMEDOUT
+  case result.Signal != "":
+    return STATUSKILLED
+  case result.ExitCode == 0:
+    return STATUSSUCCEEDED
+  default:
+    return STATUSFAILED
+  }
+}
+
+// RunHistoryAPI - Keep completed runs together in an iterable slice and is JSON friendly for API use
+type RunHistoryAPI struct {
+  Runs []JobResult
+}
+
+// historySync - Serialize appends so concurrent runs can't interleave their records
+var historySync sync.Mutex
+
+// AppendRunHistory - Append a completed run to the end of the history file, one JSON record per line
+func AppendRunHistory(historyPath string, result JobResult) (error) {
+
+  record, err := json.Marshal(result)
+  if err != nil {
+    return err
+  }
+
+  historySync.Lock()
+  defer historySync.Unlock()
+
+  historyFile, err := os.OpenFile(historyPath, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644)
+  if err != nil {
+    return err
+  }
+  defer historyFile.Close()
+
+  _, err = historyFile.Write(append(record, '\n'))
+
+  return err
+}
+
+// TrimRunHistory - Rewrite the history file without the runs past keepRuns per job or older than keepDays.  Zero keeps
+//  everything.  The latest run and latest success of each job are always kept so dependencies and metrics survive a
+//  restart.  Records that can't be read are dropped
+func TrimRunHistory(historyPath string, keepRuns int, keepDays int, now time.Time) (error) {
+
+  if keepRuns == 0 && keepDays == 0 {
+    return nil
+  }
+
+  historySync.Lock()
+  defer historySync.Unlock()
+
+  historyFile, err := os.Open(historyPath)
+  if os.IsNotExist(err) {
+    return nil
+  } else if err != nil {
+    return err
+  }
+  var records [][]byte
+  var results []JobResult
+  scanner := bufio.NewScanner(historyFile)
+  scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+  for scanner.Scan() {
+    var result JobResult
+    if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+      continue
+    }
+    records = append(records, append([]byte(nil), scanner.Bytes()...))
+    results = append(results, result)
+  }
+  historyFile.Close()
+  if err := scanner.Err(); err != nil {
+    return err
+  }
+
+  // Walk back from the newest record, keeping each job's runs until one of the limits is reached
+  keep := make([]bool, len(results))
+  keptRuns := make(map[string]int)
+  keptSuccess := make(map[string]bool)
+  removed := 0
+  for resultIndex := len(results) - 1; resultIndex >= 0; resultIndex-- {
+    result := results[resultIndex]
+    isLatest := keptRuns[result.Label] == 0
+    isLatestSuccess := result.Status == STATUSSUCCEEDED && keptSuccess[result.Label] == false
+    if isLatest == false && isLatestSuccess == false &&
+      ((keepRuns > 0 && keptRuns[result.Label] >= keepRuns) ||
+      (keepDays > 0 && now.Sub(result.EndTime) > time.Duration(keepDays) * 24 * time.Hour)) {
+      removed++
+      continue
+    }
+    keep[resultIndex] = true
+    keptRuns[result.Label]++
+    if result.Status == STATUSSUCCEEDED {
+      keptSuccess[result.Label] = true
+    }
+  }
+  if removed == 0 {
+    return nil
+  }
+
+  // Written to a temporary file and renamed into place so a crash never leaves half a history behind
+  tempFile, err := ioutil.TempFile(filepath.Dir(historyPath), filepath.Base(historyPath) + ".")
+  if err != nil {
+    return err
+  }
+  writer := bufio.NewWriter(tempFile)
+  for recordIndex, _ := range records {
+    if keep[recordIndex] == true {
+      writer.Write(append(records[recordIndex], '\n'))
+    }
+  }
+  err = writer.Flush()
+  closeErr := tempFile.Close()
+  if err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    os.Remove(tempFile.Name())
+    return err
+  }
+  os.Chmod(tempFile.Name(), 0644)
+  if err := os.Rename(tempFile.Name(), historyPath); err != nil {
+    os.Remove(tempFile.Name())
+    return err
+  }
+  logrus.Debug("Trimmed " + strconv.Itoa(removed) + " runs from the run history")
+
+  return nil
+}
+
+// ReadRunHistory - Read every run from the history file that the filter accepts, oldest first.
+//  A nil filter accepts every run
+func ReadRunHistory(historyPath string, filter func(result JobResult) (bool)) ([]JobResult, error) {
+
+  var results []JobResult
+
+  historySync.Lock()
+  defer historySync.Unlock()
+
+  historyFile, err := os.Open(historyPath)
+  if os.IsNotExist(err) {
+    return results, nil
+  } else if err != nil {
+    return nil, err
+  }
+  defer historyFile.Close()
+
+  scanner := bufio.NewScanner(historyFile)
+  scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+  for scanner.Scan() {
+
+    // Skip partially written records rather than failing the whole read
+    var result JobResult
+    if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+      continue
+    }
+    if filter == nil || filter(result) {
+      results = append(results, result)
+    }
+  }
+
+  return results, scanner.Err()
+}
+
+// GetRunHistoryByLabel - Read every run of a job.  Underscores in the label match spaces like GetJobByLabel
+func GetRunHistoryByLabel(historyPath string, label string) ([]JobResult, error) {
+
+  spacesLabel := strings.Replace(label, "_", " ", -1)
+  return ReadRunHistory(historyPath, func(result JobResult) (bool) {
+    return result.Label == label || result.Label == spacesLabel
+  })
+}
+
+// GetRunHistoryByToken - Read the run with the passed token
+func GetRunHistoryByToken(historyPath string, token string) ([]JobResult, error) {
+
+  return ReadRunHistory(historyPath, func(result JobResult) (bool) {
+    return result.Token == token
+  })
+}
+
+// GetLastRunResults - The most recent run of each job keyed by label
+func GetLastRunResults(historyPath string) (map[string]JobResult, error) {
+
+  lastResults := make(map[string]JobResult)
+  results, err := ReadRunHistory(historyPath, nil)
+  if err != nil {
+    return lastResults, err
+  }
+  for _, result := range results {
+    lastResults[result.Label] = result
+  }
+
+  return lastResults, err
+}
+
+// GetLastSuccessTimes - When each job last finished successfully, keyed by label
+func GetLastSuccessTimes(historyPath string) (map[string]time.Time, error) {
+
+  lastSuccesses := make(map[string]time.Time)
+  results, err := ReadRunHistory(historyPath, func(result JobResult) (bool) {
+    return result.Status == STATUSSUCCEEDED
+  })
+  if err != nil {
+    return lastSuccesses, err
+  }
+  for _, result := range results {
+    if result.EndTime.After(lastSuccesses[result.Label]) {
+      lastSuccesses[result.Label] = result.EndTime
+    }
+  }
+
+  return lastSuccesses, nil
+}
@@ -8,6 +8,7 @@ import (
   "os"
   "github.com/BurntSushi/toml"
   "github.com/Sirupsen/logrus"
+  "github.com/brysearl/omicrond/conf"
 )
 
 const (
@@ -28,6 +29,7 @@ const (
 // JobSchedule - Keep all the jobs together in an iterable slice
 type JobSchedule struct {
   Job          []JobConfig
+  Group        []GroupConfig
   LabelToIndex map[string]int
 }
 
@@ -35,24 +37,92 @@ type JobSchedule struct {
 type JobConfig struct {
   Label      string            // The name of the job.  Used in logging
   Command    string            // String to be run on the system
-  GroupName  string            // Used to relate jobs and in logging *unused*
+  Shell      string            // Shell the command is passed to (ex '/bin/sh -c').  Empty splits the command into words
+  User       string            // User name or uid the command runs as.  Empty runs as the daemon
+  Group      string            // Group name or gid the command runs as.  Empty uses the primary group of User
+  Env        map[string]string // Environment variables set for the command
+  EnvFile    string            // File of 'NAME=value' lines read into the environment before Env
+  ClearEnv   bool              // Start the command from an empty environment rather than the daemon's
+  WorkingDir string            // Directory the command runs in.  Empty uses the daemon's working directory
+  Umask      string            // Octal umask of the command (ex '022').  Empty uses the daemon's umask
+  Timeout    string            // Duration (ex '2h') after which the command is sent SIGTERM.  Empty never times out
+  KillGracePeriod string       // Duration the command has to exit after SIGTERM before SIGKILL.  Defaults to 10s
+  StopSignal string            // Signal sent to the process group by stop requests: TERM, INT, HUP or KILL (default)
+  MemoryMax  string            // Most memory the run may use before it is OOM killed (ex '512M', '2G')
+  CPUMax     string            // Share of a CPU the run may use (ex '50%', '200%') or cgroup's '<quota> <period>'
+  CPUWeight  int               // Relative CPU priority under contention, 1 to 10000.  cgroup's default is 100
+  PidsMax    int               // Most processes and threads the run may have at once
+  IOWeight   int               // Relative disk priority under contention, 1 to 10000.  cgroup's default is 100
+  MaxOutput  string            // Most of each output stream kept per run (ex '10M').  Empty uses the daemon default
+  KeepRuns   int               // Most runs whose logs are kept.  0 uses the daemon default
+  KeepDays   int               // Days the logs of a run are kept.  0 uses the daemon default
+  MaxLogBytes string           // Most space the job's logs may take up (ex '1G').  Oldest runs are removed first
+  Retries    int               // Extra attempts given to a failed or timed out run
+  RetryBackoff RetryBackoff    // Wait between attempts
+  RetryOnExitCodes []int       // Only retry these exit codes.  Empty retries any failure
+  GroupName  string            // Used to relate jobs, stagger their start times and in logging
   Schedule   string            // Traditional encoded string to represent the schedule
+  ScheduleMode string          // How the schedule is evaluated: legacy or cron.  Empty uses the daemon default
+  Timezone   string            // IANA time zone (ex 'America/New_York') the schedule is evaluated in.  Empty is local
+  DSTPolicy  string            // Handling of daylight saving gaps and repeats: skip, once (default) or twice
+  CatchUp    string            // Missed runs made up for after downtime or a clock jump: none (default), once or all
+  CatchUpLimit int             // Most missed runs 'all' makes up for.  Defaults to 10
   Locking    bool              // Self-locking daemon that won't step on its own toes
+  MaxConcurrent int            // Most runs of the job at once.  0 is unlimited, or one with Locking
+  ConcurrencyPolicy string     // When a job, group or daemon limit is hit: queue, skip or replace the oldest run
+  DependsOn  []JobDependency   // Upstream jobs that must complete before this job is run
+  Notify     []NotifyRule      // Where to send word of finished runs
+  Syslog     SyslogConfig      // Forwarding of output lines to syslog and the journal
   Filters    []func(currentTime time.Time) (bool)
+  location   *time.Location    // Loaded Timezone
 }
 
 // JobScheduleAPI - Keep all the jobs together in an iterable slice and is JSON friendly for API use
 type JobScheduleAPI struct {
-  Job []JobConfigAPI
+  Job   []JobConfigAPI
+  Group []GroupConfig
 }
 
 // JobConfigAPI - Object representing a single scheduled job and is JSON friendly for API use
 type JobConfigAPI struct {
   Label      string            // The name of the job.  Used in logging
   Command    string            // String to be run on the system
-  GroupName  string            // Used to relate jobs and in logging *unused*
+  Shell      string            // Shell the command is passed to (ex '/bin/sh -c').  Empty splits the command into words
+  User       string            // User name or uid the command runs as.  Empty runs as the daemon
+  Group      string            // Group name or gid the command runs as.  Empty uses the primary group of User
+  Env        map[string]string // Environment variables set for the command
+  EnvFile    string            // File of 'NAME=value' lines read into the environment before Env
+  ClearEnv   bool              // Start the command from an empty environment rather than the daemon's
+  WorkingDir string            // Directory the command runs in.  Empty uses the daemon's working directory
+  Umask      string            // Octal umask of the command (ex '022').  Empty uses the daemon's umask
+  Timeout    string            // Duration (ex '2h') after which the command is sent SIGTERM.  Empty never times out
+  KillGracePeriod string       // Duration the command has to exit after SIGTERM before SIGKILL.  Defaults to 10s
+  StopSignal string            // Signal sent to the process group by stop requests: TERM, INT, HUP or KILL (default)
+  MemoryMax  string            // Most memory the run may use before it is OOM killed (ex '512M', '2G')
+  CPUMax     string            // Share of a CPU the run may use (ex '50%', '200%') or cgroup's '<quota> <period>'
+  CPUWeight  int               // Relative CPU priority under contention, 1 to 10000.  cgroup's default is 100
+  PidsMax    int               // Most processes and threads the run may have at once
+  IOWeight   int               // Relative disk priority under contention, 1 to 10000.  cgroup's default is 100
+  MaxOutput  string            // Most of each output stream kept per run (ex '10M').  Empty uses the daemon default
+  KeepRuns   int               // Most runs whose logs are kept.  0 uses the daemon default
+  KeepDays   int               // Days the logs of a run are kept.  0 uses the daemon default
+  MaxLogBytes string           // Most space the job's logs may take up (ex '1G').  Oldest runs are removed first
+  Retries    int               // Extra attempts given to a failed or timed out run
+  RetryBackoff RetryBackoff    // Wait between attempts
+  RetryOnExitCodes []int       // Only retry these exit codes.  Empty retries any failure
+  GroupName  string            // Used to relate jobs, stagger their start times and in logging
   Locking    bool              // Self-locking daemon that won't step on its own toes
+  MaxConcurrent int            // Most runs of the job at once.  0 is unlimited, or one with Locking
+  ConcurrencyPolicy string     // When a job, group or daemon limit is hit: queue, skip or replace the oldest run
   Schedule   string            // Traditional encoded string to represent the schedule
+  ScheduleMode string          // How the schedule is evaluated: legacy or cron.  Empty uses the daemon default
+  Timezone   string            // IANA time zone (ex 'America/New_York') the schedule is evaluated in.  Empty is local
+  DSTPolicy  string            // Handling of daylight saving gaps and repeats: skip, once (default) or twice
+  CatchUp    string            // Missed runs made up for after downtime or a clock jump: none (default), once or all
+  CatchUpLimit int             // Most missed runs 'all' makes up for.  Defaults to 10
+  DependsOn  []JobDependency   // Upstream jobs that must complete before this job is run
+  Notify     []NotifyRule      // Where to send word of finished runs
+  Syslog     SyslogConfig      // Forwarding of output lines to syslog and the journal
 }
 
 
@@ -97,7 +167,7 @@ func (h *JobSchedule) WriteJobConfig(confFile string) (error) {
   schedule, err := h.MakeAPIFormat()
   if err := toml.NewEncoder(writer).Encode(schedule); err != nil {
 
-    logrus.Error("Error encoding TOML: %s", err)
+    logrus.Errorf("Error encoding TOML: %s", err)
     err = os.Rename(backupFile, confFile)
   }
 
@@ -130,6 +200,76 @@ func (h *JobSchedule) CheckConfig() error {
     if err != nil {
       return err
     }
+    err = h.Job[jobIndex].checkTimezone()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkCommand()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkCredential()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkEnvironment()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkTimeout()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkStopSignal()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkResources()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkNotifiers()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkSyslog()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkRetention()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkRetries()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkCatchUp()
+    if err != nil {
+      return err
+    }
+    err = h.Job[jobIndex].checkConcurrency()
+    if err != nil {
+      return err
+    }
+
+    // Warn about schedules that will behave differently than they did in a crontab
+    if h.Job[jobIndex].EffectiveScheduleMode() == SCHEDULELEGACY {
+      for _, difference := range h.Job[jobIndex].cronDifferences() {
+        h.Job[jobIndex].Logger().Warn("[" + h.Job[jobIndex].Label + "] schedule [" + h.Job[jobIndex].Schedule + "] differs from cron: " + difference + ".  Set ScheduleMode = \"cron\" to match cron.")
+      }
+    }
+  }
+
+  // Dependencies can only be resolved once every label is known
+  err = h.checkDependencies(titleCheck)
+  if err != nil {
+    return err
+  }
+
+  err = h.checkGroups()
+  if err != nil {
+    return err
   }
   h.LabelToIndex = titleCheck
 
@@ -140,9 +280,74 @@ func (h *JobSchedule) CheckConfig() error {
 func (j *JobConfig) ParseScheduleIntoFilters(testing bool) (error) {
 
   var err error
-  scheduleChunks := strings.Split(j.Schedule, " ")
-  if len(scheduleChunks) != 5 {
-    return errors.New("Cannot parse schedule string " + j.Label + ": " + j.Schedule)
+
+  // Load the time zone once rather than on every check
+  location, err := j.Location()
+  if err != nil {
+    return err
+  }
+  if testing == false {
+    j.location = location
+  }
+
+  // Jobs triggered only by their dependencies don't need a schedule
+  if j.Schedule == "" && len(j.DependsOn) > 0 {
+    return err
+  }
+
+  // @reboot jobs are started once by the daemon and have nothing to filter on
+  if j.IsRebootJob() {
+    return err
+  }
+
+  scheduleMode := j.EffectiveScheduleMode()
+  if scheduleMode != SCHEDULELEGACY && scheduleMode != SCHEDULECRON {
+    return errors.New("Cannot parse schedule mode " + j.Label + ": " + scheduleMode + ".  Must be either 'legacy' or 'cron'")
+  }
+
+  secondChunk, scheduleChunks, err := j.splitSchedule()
+  if err != nil {
+    return err
+  }
+
+  // Cron ORs the two day fields together when both are restricted, so they get a single filter below
+  eitherDay := scheduleMode == SCHEDULECRON && strings.HasPrefix(scheduleChunks[DAY], "*") == false &&
+    strings.HasPrefix(scheduleChunks[DAYOFWEEK], "*") == false
+
+  // Cron counts intervals from the start of each field's range rather than from zero
+  if scheduleMode == SCHEDULECRON {
+    for chunkIndex, _ := range scheduleChunks {
+      scheduleChunks[chunkIndex] = anchorIntervals(scheduleChunks[chunkIndex], fieldBounds[chunkIndex][0], fieldBounds[chunkIndex][1])
+    }
+    secondChunk = anchorIntervals(secondChunk, 0, 59)
+  }
+
+  if eitherDay {
+    dayOfWeekFunc, err := ParseDayOfWeekIntoFilter(scheduleChunks[DAYOFWEEK])
+    if err != nil {
+      return err
+    }
+    dayOfMonthFunc, err := ParseDayOfMonthIntoFilter(scheduleChunks[DAY])
+    if err != nil {
+      return err
+    }
+    if testing == false {
+      j.Filters = append(j.Filters, func(testTime time.Time) (bool) {
+        return dayOfWeekFunc(testTime) || dayOfMonthFunc(testTime)
+      })
+    }
+    scheduleChunks[DAY], scheduleChunks[DAYOFWEEK] = "*", "*"
+  }
+
+  // Six field schedules lead with seconds
+  if secondChunk != "" && secondChunk != "*" {
+    filterFunc, err := ParseSecondIntoFilter(secondChunk)
+    if err != nil {
+      return err
+    }
+    if testing == false {
+      j.Filters = append(j.Filters, filterFunc)
+    }
   }
 
   // Add filter to only run on certain days of the week
@@ -201,9 +406,123 @@ func (j *JobConfig) ParseScheduleIntoFilters(testing bool) (error) {
 
 ///////////////// SCHEDULING FUNCTIONS //////////////////////
 
+// splitSchedule - Expand any '@' shorthand (ex '@daily') and split the schedule into its fields.  The seconds field
+//  of a six field schedule is returned separately, or empty, so the remaining fields line up with five field schedules
+func (j *JobConfig) splitSchedule() (string, []string, error) {
+
+  var err error
+  schedule := j.Schedule
+  if strings.HasPrefix(schedule, "@") {
+    schedule, err = expandScheduleMacro(schedule)
+    if err != nil {
+      return "", nil, errors.New("Cannot parse schedule string " + j.Label + ": " + err.Error())
+    }
+  }
+
+  // Fields may be separated by any amount of spaces or tabs like in a crontab
+  scheduleChunks := strings.Fields(schedule)
+  switch len(scheduleChunks) {
+  case 5:
+    return "", scheduleChunks, nil
+  case 6:
+    return scheduleChunks[0], scheduleChunks[1:], nil
+  default:
+    return "", nil, errors.New("Cannot parse schedule string " + j.Label + ": " + j.Schedule)
+  }
+}
+
+// EffectiveScheduleMode - The job's ScheduleMode, falling back to the daemon default
+func (j *JobConfig) EffectiveScheduleMode() (string) {
+
+  if j.ScheduleMode != "" {
+    return j.ScheduleMode
+  }
+  if conf.Attr.ScheduleMode != "" {
+    return conf.Attr.ScheduleMode
+  }
+
+  return SCHEDULELEGACY
+}
+
+// cronDifferences - Describe each way the schedule would run differently under legacy evaluation than under cron
+func (j *JobConfig) cronDifferences() ([]string) {
+
+  var differences []string
+  _, scheduleChunks, err := j.splitSchedule()
+  if err != nil || j.IsRebootJob() {
+    return differences
+  }
+
+  if strings.HasPrefix(scheduleChunks[DAY], "*") == false && strings.HasPrefix(scheduleChunks[DAYOFWEEK], "*") == false {
+    differences = append(differences, "cron runs on either the day of month or the day of week, legacy requires both")
+  }
+  if hasIntervalStep(scheduleChunks[DAY]) {
+    differences = append(differences, "cron counts day of month intervals from the 1st")
+  }
+  if hasIntervalStep(scheduleChunks[MONTH]) {
+    differences = append(differences, "cron counts month intervals from January")
+  }
+  // Day of week intervals count from Sunday in both modes, so they need no warning
+
+  return differences
+}
+
+// HasSecondsField - Whether the schedule is six fields long and so is checked every second rather than every minute
+func (j *JobConfig) HasSecondsField() (bool) {
+
+  return len(strings.Fields(j.Schedule)) == 6
+}
+
+// CheckTime - The instant to evaluate the schedule at for a tick of the loop covering (lastCheckTime, currentTime].
+//  Five field schedules are evaluated at the minute, so a loop that reaches the tick a second or two late still
+//  checks them.  False when no minute boundary was passed since the last check
+func (j *JobConfig) CheckTime(lastCheckTime time.Time, currentTime time.Time) (time.Time, bool) {
+
+  if j.HasSecondsField() == true {
+    return currentTime, true
+  }
+  minuteTime := currentTime.Truncate(time.Minute)
+
+  return minuteTime, minuteTime.After(lastCheckTime)
+}
+
+// IsRebootJob - Whether the job uses the '@reboot' shorthand and so only runs once when the daemon starts
+func (j *JobConfig) IsRebootJob() (bool) {
+
+  return strings.ToLower(strings.TrimSpace(j.Schedule)) == "@reboot"
+}
+
+// Logger - Log entry carrying the job's label and group so log pipelines can index lines about the job
+func (j *JobConfig) Logger() (*logrus.Entry) {
+
+  fields := logrus.Fields{"label": j.Label}
+  if j.GroupName != "" {
+    fields["group"] = j.GroupName
+  }
+
+  return logrus.WithFields(fields)
+}
+
+// Resolution - How often the schedule needs to be checked.  Every second if any job has a seconds field
+func (h *JobSchedule) Resolution() (time.Duration) {
+
+  for jobIndex, _ := range h.Job {
+    if h.Job[jobIndex].HasSecondsField() {
+      return time.Second
+    }
+  }
+
+  return time.Minute
+}
+
 // CheckIfScheduled - Initiates each filter for a job and returns whether or not to run the job
 func (j *JobConfig) CheckIfScheduled(timeToCheck time.Time) (bool) {
 
+  // Without a schedule the job only runs when triggered by its dependencies.  @reboot jobs never come due
+  if j.Schedule == "" || j.IsRebootJob() {
+    return false
+  }
+
   for _, filter := range j.Filters {
     result := filter(timeToCheck)
     if result == false {
@@ -235,6 +554,7 @@ func (h *JobSchedule) MakeAPIFormat() (JobScheduleAPI, error) {
       return JobScheduleAPI{}, err
     }
   }
+  apiHandler.Group = h.Group
 
   return apiHandler, err
 }
@@ -246,9 +566,42 @@ func (j *JobConfig) MakeAPIFormat() (JobConfigAPI, error) {
   apiJobConfig := JobConfigAPI{
     Label: j.Label,
     Command: j.Command,
+    Shell: j.Shell,
+    User: j.User,
+    Group: j.Group,
+    Env: j.Env,
+    EnvFile: j.EnvFile,
+    ClearEnv: j.ClearEnv,
+    WorkingDir: j.WorkingDir,
+    Umask: j.Umask,
+    Timeout: j.Timeout,
+    KillGracePeriod: j.KillGracePeriod,
+    StopSignal: j.StopSignal,
+    MemoryMax: j.MemoryMax,
+    CPUMax: j.CPUMax,
+    CPUWeight: j.CPUWeight,
+    PidsMax: j.PidsMax,
+    IOWeight: j.IOWeight,
+    MaxOutput: j.MaxOutput,
+    KeepRuns: j.KeepRuns,
+    KeepDays: j.KeepDays,
+    MaxLogBytes: j.MaxLogBytes,
+    Retries: j.Retries,
+    RetryBackoff: j.RetryBackoff,
+    RetryOnExitCodes: j.RetryOnExitCodes,
     GroupName: j.GroupName,
     Schedule: j.Schedule,
-    Locking: j.Locking}
+    ScheduleMode: j.ScheduleMode,
+    Timezone: j.Timezone,
+    DSTPolicy: j.DSTPolicy,
+    CatchUp: j.CatchUp,
+    CatchUpLimit: j.CatchUpLimit,
+    Locking: j.Locking,
+    MaxConcurrent: j.MaxConcurrent,
+    ConcurrencyPolicy: j.ConcurrencyPolicy,
+    DependsOn: j.DependsOn,
+    Notify: j.Notify,
+    Syslog: j.Syslog}
 
   return apiJobConfig, err
 }
@@ -2,10 +2,26 @@ package job
 
 import (
   "testing"
+  "bufio"
+  "bytes"
+  "context"
+  "encoding/json"
   "time"
   "fmt"
+  "strconv"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "strings"
+  "sync"
+  "syscall"
   "github.com/Sirupsen/logrus"
   "github.com/BurntSushi/toml"
+  "github.com/brysearl/omicrond/conf"
   . "github.com/smartystreets/goconvey/convey"
 )
 
@@ -16,8 +32,9 @@ type TestingJobSchedule struct {
 }
 
 type TestingJob struct {
-  Job  JobConfig
-  Test []TestConfig
+  Job            JobConfig
+  Test           []TestConfig
+  ShouldNotParse bool
 }
 
 type TestConfig struct {
@@ -46,7 +63,7 @@ func TestJobParseAndFilterCreation(t *testing.T) {
       } else if test.ExpectedResult == false {
         verb = "not run"
       } else {
-        logrus.Error("Cannot determine verb for testcase " + string(testCaseIndex))
+        logrus.Error("Cannot determine verb for testcase " + strconv.Itoa(testCaseIndex))
       }
       Convey("With schedule [" + testCase.Job.Schedule + "] and date [" + test.TestTime.String() + "], the job should " + verb, t, func() {
         So(result, ShouldEqual, test.ExpectedResult)
@@ -55,6 +72,346 @@ func TestJobParseAndFilterCreation(t *testing.T) {
   }
 }
 
+func TestCronSyntaxConformance(t *testing.T) {
+
+  var schedule = TestingJobSchedule{}
+  err := schedule.ParseTestJobConfig("../unit_test/TestCronSyntaxConformance.toml")
+  Convey("The conformance table should parse", t, func() {
+    So(err, ShouldEqual, nil)
+  })
+
+  for _, testCase := range schedule.TestCase {
+
+    if testCase.ShouldNotParse == true {
+      parseErr := testCase.Job.ParseScheduleIntoFilters(true)
+      Convey("Schedule [" + testCase.Job.Schedule + "] should be rejected", t, func() {
+        So(parseErr, ShouldNotEqual, nil)
+      })
+      continue
+    }
+
+    for _, test := range testCase.Test {
+      result := testCase.Job.CheckIfScheduled(test.TestTime)
+      Convey(testCase.Job.Label + ": " + test.Description, t, func() {
+        So(result, ShouldEqual, test.ExpectedResult)
+      })
+    }
+  }
+
+  Convey("Only @reboot jobs should be started at boot", t, func() {
+    atBoot := JobConfig{Label: "At Boot", Command: "/bin/date", Schedule: "@reboot"}
+    daily := JobConfig{Label: "Daily", Command: "/bin/date", Schedule: "@daily"}
+    So(atBoot.IsRebootJob(), ShouldEqual, true)
+    So(daily.IsRebootJob(), ShouldEqual, false)
+    So(daily.HasSecondsField(), ShouldEqual, false)
+  })
+}
+
+func TestCronScheduleMode(t *testing.T) {
+
+  Convey("Schedules should fall back to the daemon default mode", t, func() {
+    defaultMode := JobConfig{Label: "Default", Command: "/bin/date", Schedule: "0 0 1 * 1"}
+    So(defaultMode.EffectiveScheduleMode(), ShouldEqual, conf.Attr.ScheduleMode)
+    cronMode := JobConfig{Label: "Cron", Command: "/bin/date", Schedule: "0 0 1 * 1", ScheduleMode: SCHEDULECRON}
+    So(cronMode.EffectiveScheduleMode(), ShouldEqual, SCHEDULECRON)
+  })
+
+  Convey("Schedules that cron would run differently should be called out", t, func() {
+    bothDays := JobConfig{Label: "Both Days", Command: "/bin/date", Schedule: "0 0 1 * 1"}
+    So(len(bothDays.cronDifferences()), ShouldEqual, 1)
+    oddMonths := JobConfig{Label: "Odd Months", Command: "/bin/date", Schedule: "0 0 */2 */2 *"}
+    So(len(oddMonths.cronDifferences()), ShouldEqual, 2)
+    everyFive := JobConfig{Label: "Every Five", Command: "/bin/date", Schedule: "*/5 */2 * * 1-5"}
+    So(len(everyFive.cronDifferences()), ShouldEqual, 0)
+    everyOtherDay := JobConfig{Label: "Every Other Day", Command: "/bin/date", Schedule: "0 0 * * */2"}
+    So(len(everyOtherDay.cronDifferences()), ShouldEqual, 0)
+  })
+}
+
+func TestJobTimezones(t *testing.T) {
+
+  // Count the minutes a job comes due between two instants, like the scheduling loop would check them
+  countRuns := func(j JobConfig, from time.Time, to time.Time) (int) {
+    j.ParseScheduleIntoFilters(false)
+    runs := 0
+    for instant := from; instant.Before(to); instant = instant.Add(time.Minute) {
+      if j.IsDue(instant) {
+        runs++
+      }
+    }
+    return runs
+  }
+
+  Convey("Schedules should be evaluated in the job's own time zone", t, func() {
+    tokyo := JobConfig{Label: "Tokyo", Command: "/bin/date", Schedule: "0 9 * * *", Timezone: "Asia/Tokyo"}
+    tokyo.ParseScheduleIntoFilters(false)
+    So(tokyo.IsDue(time.Date(2016, 10, 4, 0, 0, 0, 0, time.UTC)), ShouldEqual, true)
+    So(tokyo.IsDue(time.Date(2016, 10, 4, 9, 0, 0, 0, time.UTC)), ShouldEqual, false)
+  })
+
+  Convey("Unknown time zones and DST policies should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Nowhere", Command: "/bin/date", Schedule: "0 9 * * *", Timezone: "Mars/Olympus_Mons"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Sometimes", Command: "/bin/date", Schedule: "0 9 * * *", DSTPolicy: "thrice"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  // New York skips 2:00-2:59am on 2016-03-13 and repeats 1:00-1:59am on 2016-11-06
+  springFrom, springTo := time.Date(2016, 3, 13, 0, 0, 0, 0, time.UTC), time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC)
+  fallFrom, fallTo := time.Date(2016, 11, 6, 0, 0, 0, 0, time.UTC), time.Date(2016, 11, 7, 0, 0, 0, 0, time.UTC)
+
+  for _, policy := range []string{DSTSKIP, DSTONCE, DSTTWICE} {
+    gapJob := JobConfig{Label: "Gap", Command: "/bin/date", Schedule: "30 2 * * *", Timezone: "America/New_York", DSTPolicy: policy}
+    repeatJob := JobConfig{Label: "Repeat", Command: "/bin/date", Schedule: "30 1 * * *", Timezone: "America/New_York", DSTPolicy: policy}
+    hourlyJob := JobConfig{Label: "Hourly", Command: "/bin/date", Schedule: "0 * * * *", Timezone: "America/New_York", DSTPolicy: policy}
+
+    expectedGap, expectedRepeat, expectedHourly := 1, 1, 24
+    if policy == DSTSKIP {
+      expectedGap = 0
+    }
+    if policy == DSTTWICE {
+      expectedRepeat = 2
+    }
+
+    Convey("With DST policy [" + policy + "] a job in the skipped hour should run " + strconv.Itoa(expectedGap) + " times", t, func() {
+      So(countRuns(gapJob, springFrom, springTo), ShouldEqual, expectedGap)
+    })
+    Convey("With DST policy [" + policy + "] a job in the repeated hour should run " + strconv.Itoa(expectedRepeat) + " times", t, func() {
+      So(countRuns(repeatJob, fallFrom, fallTo), ShouldEqual, expectedRepeat)
+    })
+    Convey("With DST policy [" + policy + "] an hourly job should keep following the clock", t, func() {
+      So(countRuns(hourlyJob, springFrom, springTo), ShouldEqual, expectedHourly)
+      So(countRuns(hourlyJob, fallFrom, fallTo), ShouldEqual, expectedHourly)
+    })
+  }
+}
+
+func TestCommandWords(t *testing.T) {
+
+  wordTests := []struct {
+    command string
+    words   []string
+  }{
+    {"/bin/echo hello world", []string{"/bin/echo", "hello", "world"}},
+    {"/bin/echo  \t spaced   out ", []string{"/bin/echo", "spaced", "out"}},
+    {"/bin/echo 'single quoted' \"double quoted\"", []string{"/bin/echo", "single quoted", "double quoted"}},
+    {"/bin/echo 'it'\\''s' \"say \\\"hi\\\"\" back\\ slash", []string{"/bin/echo", "it's", "say \"hi\"", "back slash"}},
+    {"/bin/echo '$HOME' \"\\$HOME\" \"\\n\"", []string{"/bin/echo", "$HOME", "$HOME", "\\n"}},
+    {"/bin/echo '' \"\"", []string{"/bin/echo", "", ""}},
+    {"/bin/cat a | wc -l", []string{"/bin/cat", "a", "|", "wc", "-l"}},
+  }
+  for _, wordTest := range wordTests {
+    words, err := SplitCommandWords(wordTest.command)
+    Convey("Command [" + wordTest.command + "] should split into " + strconv.Itoa(len(wordTest.words)) + " words", t, func() {
+      So(err, ShouldEqual, nil)
+      So(words, ShouldResemble, wordTest.words)
+    })
+  }
+
+  Convey("Unterminated quotes should fail the config check", t, func() {
+    _, err := SplitCommandWords("/bin/echo 'oops")
+    So(err, ShouldNotEqual, nil)
+    _, err = SplitCommandWords("/bin/echo \"oops")
+    So(err, ShouldNotEqual, nil)
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Oops", Command: "/bin/echo 'oops", Schedule: "* * * * *"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("Commands run with a shell should support pipes and expansions", t, func() {
+    piped := JobConfig{Label: "Piped", Command: "echo $((1 + 1)) | tr 2 b", Shell: "/bin/sh -c", Schedule: "* * * * *"}
+    result, err := piped.DryRun(nil, nil, true, 5 * time.Second)
+    So(err, ShouldEqual, nil)
+    So(result.StdOut, ShouldEqual, "b\n")
+  })
+
+  Convey("One-off arguments should reach the shell command unchanged", t, func() {
+    echo := JobConfig{Label: "Echo", Command: "echo", Shell: "/bin/sh -c", Schedule: "* * * * *"}
+    result, err := echo.DryRun([]string{"it's $HOME; true"}, nil, true, 5 * time.Second)
+    So(err, ShouldEqual, nil)
+    So(result.StdOut, ShouldEqual, "it's $HOME; true\n")
+  })
+}
+
+func TestJobCredentials(t *testing.T) {
+
+  Convey("Unknown users and groups should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Ghost", Command: "/usr/bin/id", Schedule: "* * * * *", User: "omicrond-no-such-user"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Ghost", Command: "/usr/bin/id", Schedule: "* * * * *", Group: "omicrond-no-such-group"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("Jobs without a user or group should run as the daemon", t, func() {
+    daemonJob := JobConfig{Label: "Daemon", Command: "/usr/bin/id"}
+    credential, loginEnv, err := daemonJob.credential()
+    So(err, ShouldEqual, nil)
+    So(credential, ShouldEqual, nil)
+    So(len(loginEnv), ShouldEqual, 0)
+  })
+
+  Convey("Jobs with a user should get its ids and login variables", t, func() {
+    userJob := JobConfig{Label: "Nobody", Command: "/usr/bin/id", User: "nobody"}
+    credential, loginEnv, err := userJob.credential()
+    So(err, ShouldEqual, nil)
+    nobody, _ := userJob.RunAsUser()
+    So(strconv.Itoa(int(credential.Uid)), ShouldEqual, nobody.Uid)
+    So(strconv.Itoa(int(credential.Gid)), ShouldEqual, nobody.Gid)
+    So(loginEnv, ShouldContain, "HOME=" + nobody.HomeDir)
+    So(loginEnv, ShouldContain, "USER=nobody")
+    So(loginEnv, ShouldContain, "LOGNAME=nobody")
+  })
+
+  // Switching users takes root
+  if os.Geteuid() == 0 {
+    Convey("Jobs with a user should run as that user", t, func() {
+      userJob := JobConfig{Label: "Nobody", Command: "/bin/sh -c 'id -un; echo $LOGNAME'", Schedule: "* * * * *", User: "nobody"}
+      result, err := userJob.DryRun(nil, nil, true, 5 * time.Second)
+      So(err, ShouldEqual, nil)
+      So(result.StdOut, ShouldEqual, "nobody\nnobody\n")
+    })
+  }
+}
+
+func TestJobEnvironment(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  envFilePath := scratchDir + "/job.env"
+  ioutil.WriteFile(envFilePath, []byte("# Settings\nexport GREETING=\"hello from file\"\nTARGET = world\n"), 0644)
+
+  Convey("Env lines should be read like a crontab or env file", t, func() {
+    name, value, ok := ParseEnvLine("MAILTO = 'ops@example.com'")
+    So(ok, ShouldEqual, true)
+    So(name, ShouldEqual, "MAILTO")
+    So(value, ShouldEqual, "ops@example.com")
+    _, _, ok = ParseEnvLine("0 4 * * * /bin/date")
+    So(ok, ShouldEqual, false)
+  })
+
+  Convey("The environment should layer the env file, Env and one-off overrides over an empty environment", t, func() {
+    envJob := JobConfig{Label: "Env", Command: "/usr/bin/env", ClearEnv: true, EnvFile: envFilePath,
+      Env: map[string]string{"TARGET": "everyone"}}
+    env, err := envJob.environment(nil, []string{"EXTRA=1"})
+    So(err, ShouldEqual, nil)
+    So(env, ShouldResemble, []string{"GREETING=hello from file", "TARGET=world", "TARGET=everyone", "EXTRA=1"})
+  })
+
+  Convey("Jobs without environment settings should inherit the daemon's environment", t, func() {
+    plainJob := JobConfig{Label: "Plain", Command: "/usr/bin/env"}
+    env, err := plainJob.environment(nil, nil)
+    So(err, ShouldEqual, nil)
+    So(env, ShouldEqual, nil)
+  })
+
+  Convey("Bad env files, relative working directories and umasks should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Env", Command: "/usr/bin/env", Schedule: "* * * * *", EnvFile: scratchDir + "/missing.env"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Env", Command: "/usr/bin/env", Schedule: "* * * * *", WorkingDir: "relative/dir"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Env", Command: "/usr/bin/env", Schedule: "* * * * *", Umask: "999"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("Commands should run with the job's environment, working directory and umask", t, func() {
+    envJob := JobConfig{Label: "Env", Command: "echo \"$GREETING $TARGET\"; pwd; umask", Shell: "/bin/sh -c",
+      EnvFile: envFilePath, WorkingDir: scratchDir, Umask: "027"}
+    testJob := RunningJob{Config: envJob}
+    cmd, err := testJob.buildCommand()
+    So(err, ShouldEqual, nil)
+    So(cmd.Dir, ShouldEqual, scratchDir)
+
+    var stdOut bytes.Buffer
+    cmd.Stdout = &stdOut
+    So(envJob.startCommand(cmd), ShouldEqual, nil)
+    cmd.Wait()
+    So(stdOut.String(), ShouldEqual, "hello from file world\n" + scratchDir + "\n0027\n")
+  })
+}
+
+func TestJobTimeout(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+
+  Convey("Unparsable timeouts should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Hung", Command: "/bin/sleep 60", Schedule: "* * * * *", Timeout: "forever"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Hung", Command: "/bin/sleep 60", Schedule: "* * * * *", Timeout: "1m", KillGracePeriod: "-1s"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("A job past its timeout should have its whole process group terminated", t, func() {
+    // The shell leaves a grandchild sleeping behind it that must also be stopped
+    hungJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Hung", Command: "/bin/sleep 60 & wait", Shell: "/bin/sh -c", Timeout: "500ms"},
+      Channel: make(chan ChanComm),
+      StartTime: time.Now()}
+    hungJob.Run(&running)
+    result := hungJob.Result()
+    So(result.TimedOut, ShouldEqual, true)
+    So(result.Status, ShouldEqual, STATUSTIMEDOUT)
+    So(result.Signal, ShouldEqual, "terminated")
+    So(result.EndTime.Sub(result.StartTime), ShouldBeLessThan, 5 * time.Second)
+  })
+
+  Convey("A job ignoring SIGTERM should be killed after the grace period", t, func() {
+    stubbornJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Stubborn", Command: "trap '' TERM; /bin/sleep 60", Shell: "/bin/sh -c", Timeout: "500ms", KillGracePeriod: "500ms"},
+      Channel: make(chan ChanComm),
+      StartTime: time.Now()}
+    stubbornJob.Run(&running)
+    result := stubbornJob.Result()
+    So(result.Status, ShouldEqual, STATUSTIMEDOUT)
+    So(result.Signal, ShouldEqual, "killed")
+    So(result.EndTime.Sub(result.StartTime), ShouldBeLessThan, 5 * time.Second)
+  })
+}
+
+func TestStopProcessGroup(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+
+  Convey("Stop signals should be limited to TERM, INT, HUP and KILL", t, func() {
+    stopSignal, err := ParseStopSignal("sigterm")
+    So(err, ShouldEqual, nil)
+    So(stopSignal, ShouldEqual, syscall.SIGTERM)
+    _, err = ParseStopSignal("STOP")
+    So(err, ShouldNotEqual, nil)
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Stop", Command: "/bin/sleep 60", Schedule: "* * * * *", StopSignal: "USR1"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("A stop request should signal the whole process group", t, func() {
+    // The sleeping grandchild holds the output pipes open, so the run only ends once it is stopped too
+    parentJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Parent", Command: "/bin/sleep 60 & wait", Shell: "/bin/sh -c"},
+      Channel: make(chan ChanComm),
+      StartTime: time.Now()}
+
+    replies := make(chan ChanComm, 1)
+    go func() {
+      time.Sleep(500 * time.Millisecond)
+      parentJob.Channel <- ChanComm{Signal: "stop process", StopSignal: "HUP"}
+      replies <- <-parentJob.Channel
+    }()
+    parentJob.Run(&running)
+    result := parentJob.Result()
+
+    So((<-replies).Error, ShouldEqual, nil)
+    So(result.Signal, ShouldEqual, "hangup")
+    So(result.Status, ShouldEqual, STATUSKILLED)
+    So(result.EndTime.Sub(result.StartTime), ShouldBeLessThan, 5 * time.Second)
+  })
+}
+
 func (h *TestingJobSchedule) ParseTestJobConfig(confFile string) (error) {
 
   fmt.Println("Parsing unit-test file: " + confFile)
@@ -64,6 +421,9 @@ func (h *TestingJobSchedule) ParseTestJobConfig(confFile string) (error) {
   }
 
   for testCaseIndex, _ := range h.TestCase {
+    if h.TestCase[testCaseIndex].ShouldNotParse == true {
+      continue
+    }
     err := h.TestCase[testCaseIndex].Job.ParseScheduleIntoFilters(false)
     if err != nil {
       return err
@@ -71,3 +431,1093 @@ func (h *TestingJobSchedule) ParseTestJobConfig(confFile string) (error) {
   }
   return err
 }
+
+func TestJobRetries(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+
+  Convey("Invalid retry settings should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Flaky", Command: "/bin/false", Schedule: "* * * * *", Retries: -1}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Flaky", Command: "/bin/false", Schedule: "* * * * *", Retries: 2, RetryBackoff: RetryBackoff{Strategy: "linear"}}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Flaky", Command: "/bin/false", Schedule: "* * * * *", Retries: 2, RetryBackoff: RetryBackoff{Delay: "soon"}}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("Failed and timed out runs should be retried until the retries are used up", t, func() {
+    flakyJob := JobConfig{Label: "Flaky", Retries: 2}
+    So(flakyJob.ShouldRetry(JobResult{Attempt: 1, ExitCode: 1, Status: STATUSFAILED}), ShouldEqual, true)
+    So(flakyJob.ShouldRetry(JobResult{Attempt: 2, ExitCode: -1, Status: STATUSTIMEDOUT}), ShouldEqual, true)
+    So(flakyJob.ShouldRetry(JobResult{Attempt: 3, ExitCode: 1, Status: STATUSFAILED}), ShouldEqual, false)
+    So(flakyJob.ShouldRetry(JobResult{Attempt: 1, ExitCode: 0, Status: STATUSSUCCEEDED}), ShouldEqual, false)
+    So(flakyJob.ShouldRetry(JobResult{Attempt: 1, ExitCode: -1, Status: STATUSKILLED}), ShouldEqual, false)
+  })
+
+  Convey("RetryOnExitCodes should limit which failures are retried", t, func() {
+    flakyJob := JobConfig{Label: "Flaky", Retries: 2, RetryOnExitCodes: []int{75}}
+    So(flakyJob.ShouldRetry(JobResult{Attempt: 1, ExitCode: 75, Status: STATUSFAILED}), ShouldEqual, true)
+    So(flakyJob.ShouldRetry(JobResult{Attempt: 1, ExitCode: 1, Status: STATUSFAILED}), ShouldEqual, false)
+  })
+
+  Convey("Backoffs should be fixed, exponential up to their cap, or jittered", t, func() {
+    fixedJob := JobConfig{Label: "Fixed", RetryBackoff: RetryBackoff{Delay: "10s"}}
+    So(fixedJob.RetryDelay(1), ShouldEqual, 10 * time.Second)
+    So(fixedJob.RetryDelay(3), ShouldEqual, 10 * time.Second)
+    defaultJob := JobConfig{Label: "Default"}
+    So(defaultJob.RetryDelay(1), ShouldEqual, defaultRetryDelay)
+    exponentialJob := JobConfig{Label: "Exponential", RetryBackoff: RetryBackoff{Strategy: BACKOFFEXPONENTIAL, Delay: "10s", MaxDelay: "1m"}}
+    So(exponentialJob.RetryDelay(1), ShouldEqual, 10 * time.Second)
+    So(exponentialJob.RetryDelay(2), ShouldEqual, 20 * time.Second)
+    So(exponentialJob.RetryDelay(3), ShouldEqual, 40 * time.Second)
+    So(exponentialJob.RetryDelay(4), ShouldEqual, time.Minute)
+    So(exponentialJob.RetryDelay(100), ShouldEqual, time.Minute)
+    uncappedJob := JobConfig{Label: "Uncapped", RetryBackoff: RetryBackoff{Strategy: BACKOFFEXPONENTIAL, Delay: "10s"}}
+    So(uncappedJob.RetryDelay(10), ShouldEqual, defaultRetryMaxDelay)
+    So(uncappedJob.RetryDelay(64), ShouldEqual, defaultRetryMaxDelay)
+    jitterJob := JobConfig{Label: "Jitter", RetryBackoff: RetryBackoff{Delay: "10s", Jitter: true}}
+    for attempt := 1; attempt < 20; attempt++ {
+      So(jitterJob.RetryDelay(attempt), ShouldBeBetweenOrEqual, 5 * time.Second, 10 * time.Second)
+    }
+  })
+
+  Convey("Retries should keep the token but log to their own directory", t, func() {
+    retriedJob := RunningJob{Token: CreateRunToken(), Config: JobConfig{Label: "Flaky"}, Attempt: 1, ExitCode: 1}
+    retriedJob.LogDir = retriedJob.DetermineLoggingDir()
+    firstLogDir := retriedJob.LogDir
+    retriedJob.PrepareNextAttempt()
+    So(retriedJob.Attempt, ShouldEqual, 2)
+    So(retriedJob.LogDir, ShouldEqual, firstLogDir + "-2")
+    So(retriedJob.Result().Token, ShouldEqual, filepath.Base(firstLogDir))
+  })
+
+  Convey("A stop request while waiting to retry should cancel the remaining attempts", t, func() {
+    waitingJob := RunningJob{Token: CreateRunToken(), Config: JobConfig{Label: "Flaky"}, Channel: make(chan ChanComm)}
+    retrying := make(chan bool, 1)
+    go func() {
+      retrying <- waitingJob.WaitForRetry(time.Minute)
+    }()
+    waitingJob.Channel <- ChanComm{Signal: "stop process"}
+    reply := <-waitingJob.Channel
+    So(reply.Signal, ShouldEqual, "success")
+    So(<-retrying, ShouldEqual, false)
+    So(waitingJob.WaitForRetry(10 * time.Millisecond), ShouldEqual, true)
+  })
+}
+
+func TestCatchUp(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+
+  Convey("Unknown catch up policies should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Daily", Command: "/bin/true", Schedule: "0 3 * * *", CatchUp: "sometimes"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Daily", Command: "/bin/true", Schedule: "0 3 * * *", CatchUp: CATCHUPALL, CatchUpLimit: -1}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("Missed runs should follow the job's catch up policy", t, func() {
+    downAt := time.Date(2026, 3, 1, 23, 30, 0, 0, time.Local)
+    upAt := time.Date(2026, 3, 5, 3, 0, 0, 0, time.Local)
+    dailyJob := JobConfig{Label: "Daily", Command: "/bin/true", Schedule: "0 3 * * *"}
+    So(dailyJob.ParseScheduleIntoFilters(false), ShouldEqual, nil)
+
+    // The run due as the daemon comes back is left to the scheduling loop
+    So(dailyJob.MissedRuns(downAt, upAt), ShouldBeEmpty)
+    dailyJob.CatchUp = CATCHUPONCE
+    So(dailyJob.MissedRuns(downAt, upAt), ShouldResemble, []time.Time{time.Date(2026, 3, 4, 3, 0, 0, 0, time.Local)})
+    dailyJob.CatchUp = CATCHUPALL
+    So(dailyJob.MissedRuns(downAt, upAt), ShouldResemble, []time.Time{
+      time.Date(2026, 3, 2, 3, 0, 0, 0, time.Local),
+      time.Date(2026, 3, 3, 3, 0, 0, 0, time.Local),
+      time.Date(2026, 3, 4, 3, 0, 0, 0, time.Local)})
+    dailyJob.CatchUpLimit = 2
+    So(dailyJob.MissedRuns(downAt, upAt), ShouldResemble, []time.Time{
+      time.Date(2026, 3, 3, 3, 0, 0, 0, time.Local),
+      time.Date(2026, 3, 4, 3, 0, 0, 0, time.Local)})
+  })
+
+  Convey("Missed runs should only be looked for within the catch up window", t, func() {
+    upAt := time.Date(2026, 3, 4, 12, 0, 0, 0, time.Local)
+    yearlyJob := JobConfig{Label: "Yearly", Schedule: "0 0 1 1 *", CatchUp: CATCHUPONCE}
+    So(yearlyJob.ParseScheduleIntoFilters(false), ShouldEqual, nil)
+    So(yearlyJob.MissedRuns(upAt.AddDate(-2, 0, 0), upAt), ShouldBeEmpty)
+    So(yearlyJob.MissedRuns(upAt.AddDate(-2, 0, 0), time.Date(2026, 1, 3, 0, 0, 0, 0, time.Local)), ShouldResemble, []time.Time{
+      time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)})
+
+    secondsJob := JobConfig{Label: "Seconds", Schedule: "0 * * * * *", CatchUp: CATCHUPALL, CatchUpLimit: 100}
+    So(secondsJob.ParseScheduleIntoFilters(false), ShouldEqual, nil)
+    So(len(secondsJob.MissedRuns(upAt.Add(-24 * time.Hour), upAt)), ShouldEqual, 59)
+  })
+
+  Convey("The last evaluated time should survive a restart", t, func() {
+    lastEvaluatedPath := scratchDir + "/last_evaluated"
+    lastEvaluated, err := ReadLastEvaluated(lastEvaluatedPath)
+    So(err, ShouldEqual, nil)
+    So(lastEvaluated.IsZero(), ShouldEqual, true)
+    evaluatedTime := time.Now().Truncate(time.Minute)
+    So(WriteLastEvaluated(lastEvaluatedPath, evaluatedTime), ShouldEqual, nil)
+    lastEvaluated, err = ReadLastEvaluated(lastEvaluatedPath)
+    So(err, ShouldEqual, nil)
+    So(lastEvaluated.Equal(evaluatedTime), ShouldEqual, true)
+  })
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+
+  Convey("Invalid concurrency settings should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Busy", Command: "/bin/true", Schedule: "* * * * *", MaxConcurrent: -1}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Busy", Command: "/bin/true", Schedule: "* * * * *", ConcurrencyPolicy: "wait"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("Locking should be a job limit of one that skips by default", t, func() {
+    lockedJob := JobConfig{Label: "Locked", Locking: true}
+    So(lockedJob.JobLimit(), ShouldEqual, 1)
+    So(lockedJob.LimitPolicy(LIMITJOB), ShouldEqual, LIMITSKIP)
+    So(lockedJob.LimitPolicy(LIMITGROUP), ShouldEqual, LIMITQUEUE)
+    lockedJob.MaxConcurrent = 3
+    lockedJob.ConcurrencyPolicy = LIMITREPLACE
+    So(lockedJob.JobLimit(), ShouldEqual, 3)
+    So(lockedJob.LimitPolicy(LIMITDAEMON), ShouldEqual, LIMITREPLACE)
+  })
+
+  Convey("The tracker should report the first full limit and its oldest run", t, func() {
+    startTime := time.Now()
+    running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: map[string]RunningJob{
+      "a": RunningJob{Token: "a", Config: JobConfig{Label: "Busy", GroupName: "Batch"}, StartTime: startTime.Add(-time.Minute)},
+      "b": RunningJob{Token: "b", Config: JobConfig{Label: "Busy", GroupName: "Batch"}, StartTime: startTime},
+      "c": RunningJob{Token: "c", Config: JobConfig{Label: "Other", GroupName: "Batch"}, StartTime: startTime.Add(-time.Hour)},
+      "d": RunningJob{Token: "d", Config: JobConfig{Label: "Loner"}, StartTime: startTime.Add(-2 * time.Hour)}}}
+
+    newJob := RunningJob{Config: JobConfig{Label: "Busy", GroupName: "Batch", MaxConcurrent: 2}}
+    limit, oldestToken := running.CheckLimits(newJob, 0, 0)
+    So(limit, ShouldEqual, LIMITJOB)
+    So(oldestToken, ShouldEqual, "a")
+
+    newJob.Config.MaxConcurrent = 5
+    limit, oldestToken = running.CheckLimits(newJob, 3, 0)
+    So(limit, ShouldEqual, LIMITGROUP)
+    So(oldestToken, ShouldEqual, "c")
+
+    limit, oldestToken = running.CheckLimits(newJob, 5, 4)
+    So(limit, ShouldEqual, LIMITDAEMON)
+    So(oldestToken, ShouldEqual, "d")
+
+    limit, oldestToken = running.CheckLimits(newJob, 5, 5)
+    So(limit, ShouldEqual, "")
+    So(oldestToken, ShouldEqual, "")
+  })
+
+  Convey("Runs started at the same time should not share the last slot", t, func() {
+    running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+    var starting sync.WaitGroup
+    for runIndex := 0; runIndex < 20; runIndex++ {
+      starting.Add(1)
+      go func(runToken string) {
+        defer starting.Done()
+        running.AddWithinLimits(&RunningJob{Token: runToken, Config: JobConfig{Label: "Locked", Locking: true}}, JobSchedule{}, 0)
+      }(strconv.Itoa(runIndex))
+    }
+    starting.Wait()
+    So(len(running.Jobs), ShouldEqual, 1)
+    So(running.CountRunning(), ShouldEqual, 1)
+  })
+
+  Convey("Runs that don't fit should be queued and start in order as slots free up", t, func() {
+    running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+    queuedConfig := JobConfig{Label: "Queued", MaxConcurrent: 1, ConcurrencyPolicy: LIMITQUEUE}
+    first := RunningJob{Token: "first", Config: queuedConfig}
+    second := RunningJob{Token: "second", Config: queuedConfig, Admitted: make(chan bool)}
+    third := RunningJob{Token: "third", Config: queuedConfig, Admitted: make(chan bool)}
+    limit, _ := running.AddWithinLimits(&first, JobSchedule{}, 0)
+    So(limit, ShouldEqual, "")
+    So(first.Queued, ShouldEqual, false)
+    limit, _ = running.AddWithinLimits(&second, JobSchedule{}, 0)
+    So(limit, ShouldEqual, LIMITJOB)
+    So(second.Queued, ShouldEqual, true)
+    running.AddWithinLimits(&third, JobSchedule{}, 0)
+    So(len(running.Jobs), ShouldEqual, 3)
+    So(running.CountRunning(), ShouldEqual, 1)
+
+    So(running.Remove("first", JobSchedule{}, 0), ShouldEqual, true)
+    So(second.WaitForRoom(), ShouldEqual, true)
+    So(running.Jobs["second"].Queued, ShouldEqual, false)
+    So(running.Jobs["third"].Queued, ShouldEqual, true)
+    So(running.CountRunning(), ShouldEqual, 1)
+
+    So(running.Remove("second", JobSchedule{}, 0), ShouldEqual, true)
+    So(third.WaitForRoom(), ShouldEqual, true)
+    So(running.Remove("missing", JobSchedule{}, 0), ShouldEqual, false)
+  })
+
+  Convey("Replacing should queue the new run rather than go over the limit", t, func() {
+    running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: map[string]RunningJob{
+      "oldest": RunningJob{Token: "oldest", Config: JobConfig{Label: "Locked", Locking: true}, StartTime: time.Now()}}}
+    replacing := RunningJob{Token: "replacing", Config: JobConfig{Label: "Locked", Locking: true, ConcurrencyPolicy: LIMITREPLACE}}
+    limit, oldestToken := running.AddWithinLimits(&replacing, JobSchedule{}, 0)
+    So(limit, ShouldEqual, LIMITJOB)
+    So(oldestToken, ShouldEqual, "oldest")
+    So(replacing.Queued, ShouldEqual, true)
+    So(running.CountRunning(), ShouldEqual, 1)
+  })
+
+  Convey("Stopping a tracked run should go through its channel", t, func() {
+    stoppedJob := RunningJob{Token: "a", Config: JobConfig{Label: "Busy"}, Channel: make(chan ChanComm)}
+    running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: map[string]RunningJob{"a": stoppedJob}}
+    go func() {
+      stoppedJob.WaitForRetry(time.Minute)
+    }()
+    So(running.StopRun("a"), ShouldEqual, nil)
+    So(running.StopRun("missing"), ShouldNotEqual, nil)
+  })
+
+  Convey("Stopping a queued run should drop it before it starts", t, func() {
+    queuedJob := RunningJob{Token: "a", Config: JobConfig{Label: "Busy"}, Queued: true, Channel: make(chan ChanComm), Admitted: make(chan bool)}
+    running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: map[string]RunningJob{"a": queuedJob}}
+    started := make(chan bool, 1)
+    go func() {
+      started <- queuedJob.WaitForRoom()
+    }()
+    So(running.StopRun("a"), ShouldEqual, nil)
+    So(<-started, ShouldEqual, false)
+  })
+
+  Convey("Stopping a run that has already finished should not block", t, func() {
+    finishedJob := RunningJob{Token: "a", Config: JobConfig{Label: "Busy"}, Channel: make(chan ChanComm), Done: make(chan bool)}
+    running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: map[string]RunningJob{"a": finishedJob}}
+    close(finishedJob.Done)
+    So(running.StopRun("a"), ShouldEqual, nil)
+  })
+}
+
+func TestResourceLimits(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+
+  Convey("Limits should be translated into cgroup v2 values", t, func() {
+    memoryMax, err := parseMemoryMax("512M")
+    So(err, ShouldEqual, nil)
+    So(memoryMax, ShouldEqual, "536870912")
+    memoryMax, err = parseMemoryMax("max")
+    So(memoryMax, ShouldEqual, "max")
+    _, err = parseMemoryMax("lots")
+    So(err, ShouldNotEqual, nil)
+    cpuMax, err := parseCPUMax("150%")
+    So(err, ShouldEqual, nil)
+    So(cpuMax, ShouldEqual, "150000 100000")
+    cpuMax, err = parseCPUMax("max 100000")
+    So(cpuMax, ShouldEqual, "max 100000")
+    _, err = parseCPUMax("half")
+    So(err, ShouldNotEqual, nil)
+  })
+
+  Convey("Invalid limits should fail the config check", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Hog", Command: "/bin/true", Schedule: "* * * * *", CPUWeight: 20000}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Hog", Command: "/bin/true", Schedule: "* * * * *", MemoryMax: "1.5G"}}}
+    So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    schedule = JobSchedule{Job: []JobConfig{{Label: "Hog", Command: "/bin/true", Schedule: "* * * * *", MemoryMax: "1G", CPUMax: "50%", PidsMax: 64}}}
+    So(schedule.CheckConfig(), ShouldEqual, nil)
+  })
+
+  Convey("Each run should get its own cgroup holding the job's limits", t, func() {
+    hogJob := RunningJob{Token: CreateRunToken(), Attempt: 2, Config: JobConfig{Label: "Memory Hog", MemoryMax: "1G", CPUMax: "50%", CPUWeight: 50, PidsMax: 64, IOWeight: 10}}
+    cgroupPath, err := hogJob.createCgroup(scratchDir + "/omicrond")
+    So(err, ShouldEqual, nil)
+    So(cgroupPath, ShouldEqual, scratchDir + "/omicrond/Memory_Hog-" + hogJob.Token + "-2")
+    for limitFile, value := range map[string]string{"memory.max": "1073741824", "cpu.max": "50000 100000", "cpu.weight": "50", "pids.max": "64", "io.weight": "default 10"} {
+      written, _ := ioutil.ReadFile(cgroupPath + "/" + limitFile)
+      So(string(written), ShouldEqual, value)
+    }
+  })
+
+  Convey("Usage should be read back from the cgroup", t, func() {
+    cgroupPath := scratchDir + "/usage"
+    os.MkdirAll(cgroupPath, 0755)
+    ioutil.WriteFile(cgroupPath + "/memory.current", []byte("4096\n"), 0644)
+    ioutil.WriteFile(cgroupPath + "/pids.current", []byte("3\n"), 0644)
+    ioutil.WriteFile(cgroupPath + "/cpu.stat", []byte("usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n"), 0644)
+    usage := readCgroupUsage(cgroupPath)
+    So(usage.Memory, ShouldEqual, 4096)
+    So(usage.Pids, ShouldEqual, 3)
+    So(usage.CPUTime, ShouldEqual, 1500 * time.Millisecond)
+    apiRunningJob, err := (&RunningJob{Token: "a", CgroupPath: cgroupPath, PID: 42}).MakeAPIFormat("a")
+    So(err, ShouldEqual, nil)
+    So(apiRunningJob.MemUse, ShouldEqual, 4096)
+    So(apiRunningJob.PID, ShouldEqual, 42)
+  })
+
+  Convey("Jobs with limits should not run without cgroup v2", t, func() {
+    cgroupRoot := conf.Attr.CgroupRoot
+    conf.Attr.CgroupRoot = scratchDir + "/omicrond"
+    defer func() { conf.Attr.CgroupRoot = cgroupRoot }()
+    hogJob := RunningJob{Token: CreateRunToken(), Config: JobConfig{Label: "Hog", PidsMax: 64}}
+    _, err := hogJob.joinCgroup(nil)
+    So(err, ShouldNotEqual, nil)
+    hogJob.Config.PidsMax = 0
+    cgroupDir, err := hogJob.joinCgroup(nil)
+    So(err, ShouldEqual, nil)
+    So(cgroupDir, ShouldEqual, nil)
+  })
+
+  Convey("Anything a run leaves behind should be killed before its cgroup is removed", t, func() {
+    leftover := exec.Command("/bin/sleep", "30")
+    leftover.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+    So(leftover.Start(), ShouldEqual, nil)
+    cgroupPath := scratchDir + "/leftover"
+    os.MkdirAll(cgroupPath, 0755)
+    (&RunningJob{Token: "a", Config: JobConfig{Label: "Leaky"}, CgroupPath: cgroupPath, PID: leftover.Process.Pid}).removeCgroup()
+    _, err := os.Stat(cgroupPath)
+    So(os.IsNotExist(err), ShouldEqual, true)
+    leftover.Wait()
+    So(leftover.ProcessState.Sys().(syscall.WaitStatus).Signal(), ShouldEqual, syscall.SIGKILL)
+  })
+}
+
+func TestProcessStats(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+
+  Convey("The command name should not confuse the stat parser", t, func() {
+    os.MkdirAll(scratchDir + "/proc/4242", 0755)
+    rawStat := "4242 (odd) name (x)) S 1 4242 4242 0 -1 4194560 500 0 0 0 150 50 10 20 20 0 1 0 100 1000000 256 18446744073709551615"
+    ioutil.WriteFile(scratchDir + "/proc/4242/stat", []byte(rawStat), 0644)
+    ioutil.WriteFile(scratchDir + "/proc/4242/io", []byte("rchar: 10\nwchar: 20\nread_bytes: 4096\nwrite_bytes: 8192\n"), 0644)
+    stats := readProcessTree(scratchDir + "/proc", 4242)
+    So(stats.Children, ShouldEqual, 0)
+    So(stats.RSS, ShouldEqual, 256 * int64(os.Getpagesize()))
+    So(stats.UserTime, ShouldEqual, 1600 * time.Millisecond)
+    So(stats.SystemTime, ShouldEqual, 700 * time.Millisecond)
+    So(stats.ReadBytes, ShouldEqual, 4096)
+    So(stats.WriteBytes, ShouldEqual, 8192)
+  })
+
+  Convey("A running job should report its whole process tree", t, func() {
+    treeJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Tree", Command: "/bin/sleep 2 & /bin/sleep 2 & wait", Shell: "/bin/sh -c"},
+      Channel: make(chan ChanComm, 1),
+      StartTime: time.Now()}
+    running.Sync.Lock()
+    running.Jobs[treeJob.Token] = treeJob
+    running.Sync.Unlock()
+    finished := make(chan JobResult, 1)
+    go func() {
+      treeJob.Run(&running)
+      finished <- treeJob.Result()
+    }()
+
+    time.Sleep(500 * time.Millisecond)
+    running.Sync.RLock()
+    trackedJob := running.Jobs[treeJob.Token]
+    running.Sync.RUnlock()
+    apiRunningJob, err := trackedJob.MakeAPIFormat(trackedJob.Token)
+    So(err, ShouldEqual, nil)
+    So(apiRunningJob.PID, ShouldNotEqual, 0)
+    So(apiRunningJob.Children, ShouldEqual, 2)
+    So(apiRunningJob.RSS, ShouldBeGreaterThan, 0)
+
+    result := <-finished
+    So(result.Status, ShouldEqual, STATUSSUCCEEDED)
+    So(result.Usage.MaxRSS, ShouldBeGreaterThan, 0)
+  })
+}
+
+func TestNotifiers(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+
+  Convey("Notify rules should need a known backend, outcomes and what the backend needs", t, func() {
+    for _, rule := range []NotifyRule{
+      {Type: NOTIFYSMTP, To: []string{"ops@example.com"}},
+      {On: []string{"always"}, Type: NOTIFYSMTP, To: []string{"ops@example.com"}},
+      {On: []string{NOTIFYFAILURE}, Type: "pager"},
+      {On: []string{NOTIFYFAILURE}, Type: NOTIFYSMTP},
+      {On: []string{NOTIFYFAILURE}, Type: NOTIFYWEBHOOK, URL: "ftp://example.com"},
+      {On: []string{NOTIFYFAILURE}, Type: NOTIFYCOMMAND},
+      {On: []string{NOTIFYFAILURE}, Type: NOTIFYWEBHOOK, URL: "http://example.com", Body: "{{.Label"}} {
+      schedule := JobSchedule{Job: []JobConfig{{Label: "Noisy", Command: "/bin/true", Schedule: "* * * * *", Notify: []NotifyRule{rule}}}}
+      So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    }
+  })
+
+  Convey("Rules should only fire on their outcomes", t, func() {
+    failureRule := NotifyRule{On: []string{NOTIFYFAILURE}}
+    timeoutRule := NotifyRule{On: []string{NOTIFYTIMEOUT}}
+    outputRule := NotifyRule{On: []string{NOTIFYOUTPUT, NOTIFYSUCCESS}}
+    timedOut := NotifyMessage{JobResult: JobResult{Status: STATUSTIMEDOUT, TimedOut: true}}
+    So(failureRule.Matches(timedOut), ShouldEqual, true)
+    So(timeoutRule.Matches(timedOut), ShouldEqual, true)
+    So(outputRule.Matches(timedOut), ShouldEqual, false)
+    So(outputRule.Matches(NotifyMessage{JobResult: JobResult{Status: STATUSFAILED}, StdErr: "oops"}), ShouldEqual, true)
+    So(outputRule.Matches(NotifyMessage{JobResult: JobResult{Status: STATUSSUCCEEDED}}), ShouldEqual, true)
+  })
+
+  Convey("A failed run should be sent to every matching backend, even with retries left when they are cancelled", t, func() {
+    smtpAddress, mails := startTestSMTPServer()
+    smtpConfig := conf.Attr.SMTPAddress
+    conf.Attr.SMTPAddress = smtpAddress
+    defer func() { conf.Attr.SMTPAddress = smtpConfig }()
+
+    webhooks := make(chan string, 2)
+    webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      body, _ := ioutil.ReadAll(r.Body)
+      webhooks <- string(body)
+    }))
+    defer webhookServer.Close()
+
+    commandOutput := scratchDir + "/notified"
+    noisyJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Noisy", Command: "echo hello; exit 3", Shell: "/bin/sh -c", Retries: 2, Env: map[string]string{"NOISY_TEAM": "ops"}, Notify: []NotifyRule{
+        {On: []string{NOTIFYFAILURE}, Type: NOTIFYSMTP, To: []string{"ops@example.com"}},
+        {On: []string{NOTIFYOUTPUT}, Type: NOTIFYWEBHOOK, URL: webhookServer.URL, Body: "{\"job\": {{json .Label}}, \"exitCode\": {{.ExitCode}}}"},
+        {On: []string{NOTIFYFAILURE}, Type: NOTIFYCOMMAND, Command: "echo $OMICROND_STATUS $OMICROND_EXIT_CODE $NOISY_TEAM > " + commandOutput},
+        {On: []string{NOTIFYSUCCESS}, Type: NOTIFYWEBHOOK, URL: webhookServer.URL}}},
+      Channel: make(chan ChanComm, 1),
+      StartTime: time.Now()}
+    noisyJob.Run(&running)
+    noisyJob.Notify()
+
+    select {
+    case mail := <-mails:
+      So(mail, ShouldContainSubstring, "Subject: [omicrond] Noisy failed on ")
+      So(mail, ShouldContainSubstring, "Exit code: 3")
+      So(mail, ShouldContainSubstring, "hello")
+    case <-time.After(5 * time.Second):
+      So("no mail was sent", ShouldBeEmpty)
+    }
+    select {
+    case webhook := <-webhooks:
+      So(webhook, ShouldEqual, "{\"job\": \"Noisy\", \"exitCode\": 3}")
+    case <-time.After(5 * time.Second):
+      So("no webhook was sent", ShouldBeEmpty)
+    }
+    var notified []byte
+    for wait := 0; wait < 50 && len(notified) == 0; wait++ {
+      time.Sleep(100 * time.Millisecond)
+      notified, _ = ioutil.ReadFile(commandOutput)
+    }
+    So(string(notified), ShouldEqual, "failed 3 ops\n")
+    So(len(webhooks), ShouldEqual, 0)
+  })
+}
+
+// startTestSMTPServer - Just enough of an SMTP server to accept one message and hand it back
+func startTestSMTPServer() (string, chan string) {
+
+  listener, _ := net.Listen("tcp", "127.0.0.1:0")
+  mails := make(chan string, 1)
+  go func() {
+    defer listener.Close()
+    conn, err := listener.Accept()
+    if err != nil {
+      return
+    }
+    defer conn.Close()
+
+    reader := bufio.NewReader(conn)
+    fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+    var mail bytes.Buffer
+    inData := false
+    for {
+      line, err := reader.ReadString('\n')
+      if err != nil {
+        return
+      }
+      if inData {
+        if line == ".\r\n" {
+          inData = false
+          mails <- mail.String()
+          fmt.Fprint(conn, "250 OK\r\n")
+        } else {
+          mail.WriteString(line)
+        }
+        continue
+      }
+      switch strings.ToUpper(strings.TrimSpace(strings.SplitN(line, " ", 2)[0])) {
+      case "DATA":
+        inData = true
+        fmt.Fprint(conn, "354 Go ahead\r\n")
+      case "QUIT":
+        fmt.Fprint(conn, "221 Bye\r\n")
+        return
+      default:
+        fmt.Fprint(conn, "250 OK\r\n")
+      }
+    }
+  }()
+
+  return listener.Addr().String(), mails
+}
+
+func TestLogRetention(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+
+  // makeRun - Lay out a finished run's logs as if it wrote its last output at modified
+  makeRun := func(date string, label string, runToken string, output string, modified time.Time) (string) {
+    runPath := filepath.Join(conf.Attr.LoggingPath, date, label, runToken)
+    os.MkdirAll(runPath, 0755)
+    ioutil.WriteFile(runPath + "/stdout.txt", []byte(output), 0644)
+    ioutil.WriteFile(runPath + "/stderr.txt", []byte{}, 0644)
+    os.Chtimes(runPath + "/stdout.txt", modified, modified)
+    os.Chtimes(runPath + "/stderr.txt", modified, modified)
+    return runPath
+  }
+
+  Convey("Bad output limits and retention should fail the config check", t, func() {
+    for _, badJob := range []JobConfig{
+      {Label: "Chatty", Command: "/bin/true", Schedule: "* * * * *", MaxOutput: "lots"},
+      {Label: "Chatty", Command: "/bin/true", Schedule: "* * * * *", MaxLogBytes: "1.5G"},
+      {Label: "Chatty", Command: "/bin/true", Schedule: "* * * * *", KeepRuns: -1},
+      {Label: "Chatty", Command: "/bin/true", Schedule: "* * * * *", KeepDays: -1}} {
+      schedule := JobSchedule{Job: []JobConfig{badJob}}
+      So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    }
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Chatty", Command: "/bin/true", Schedule: "* * * * *", MaxOutput: "10M", MaxLogBytes: "1G", KeepRuns: 5, KeepDays: 7}}}
+    So(schedule.CheckConfig(), ShouldEqual, nil)
+  })
+
+  Convey("Output past the limit should be dropped and marked", t, func() {
+    chattyJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Chatty", Command: "for i in 1 2 3 4 5 6 7 8 9; do echo line$i; done", Shell: "/bin/sh -c", MaxOutput: "20"},
+      Channel: make(chan ChanComm, 1),
+      StartTime: time.Now()}
+    chattyJob.Run(&running)
+    output, err := ioutil.ReadFile(chattyJob.StdOutPath())
+    So(err, ShouldEqual, nil)
+    So(string(output), ShouldStartWith, "line1\nline2\nline3\nli\n")
+    So(string(output), ShouldContainSubstring, "[omicrond: output truncated at 20 bytes, 34 bytes dropped]")
+  })
+
+  Convey("Long lines and output without newlines should be cut into pieces rather than hang the run", t, func() {
+    binaryJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Binary", Command: "head -c 200000 /dev/zero; echo; echo done", Shell: "/bin/sh -c"},
+      Channel: make(chan ChanComm, 1),
+      StartTime: time.Now()}
+    finished := make(chan bool)
+    go func() {
+      binaryJob.Run(&running)
+      close(finished)
+    }()
+    select {
+    case <-finished:
+    case <-time.After(10 * time.Second):
+      So("the run never finished", ShouldBeEmpty)
+    }
+    So(binaryJob.ExitCode, ShouldEqual, 0)
+    output, err := ioutil.ReadFile(binaryJob.StdOutPath())
+    So(err, ShouldEqual, nil)
+    So(len(output), ShouldEqual, 200000 + 4 + len("done\n"))
+    So(string(output), ShouldEndWith, "\ndone\n")
+  })
+
+  Convey("Processes left in the background should not keep the run going", t, func() {
+    defer func(drainTimeout time.Duration) { outputDrainTimeout = drainTimeout }(outputDrainTimeout)
+    outputDrainTimeout = 100 * time.Millisecond
+    detachingJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Detaching", Command: "echo started; sleep 5 &", Shell: "/bin/sh -c"},
+      Channel: make(chan ChanComm, 1),
+      StartTime: time.Now()}
+    finished := make(chan bool)
+    go func() {
+      detachingJob.Run(&running)
+      close(finished)
+    }()
+    select {
+    case <-finished:
+    case <-time.After(3 * time.Second):
+      So("the run waited on its background process", ShouldBeEmpty)
+    }
+    So(detachingJob.ExitCode, ShouldEqual, 0)
+    output, err := ioutil.ReadFile(detachingJob.StdOutPath())
+    So(err, ShouldEqual, nil)
+    So(string(output), ShouldEqual, "started\n")
+  })
+
+  Convey("Finished runs should be compressed and still readable", t, func() {
+    os.RemoveAll(conf.Attr.LoggingPath)
+    finishedPath := makeRun("2026-10-01", "Chatty", "aaaa", "hello\n", time.Now())
+    activePath := makeRun("2026-10-01", "Chatty", "bbbb-2", "still going\n", time.Now())
+    So(HousekeepLogs(conf.Attr.LoggingPath, nil, map[string]bool{"bbbb": true}, time.Now()), ShouldEqual, nil)
+
+    _, err := os.Stat(finishedPath + "/stdout.txt")
+    So(os.IsNotExist(err), ShouldEqual, true)
+    _, err = os.Stat(activePath + "/stdout.txt")
+    So(err, ShouldEqual, nil)
+
+    logFile, _, err := OpenCompressedLog(finishedPath + "/stdout.txt")
+    So(err, ShouldEqual, nil)
+    defer logFile.Close()
+    output, _ := ioutil.ReadAll(logFile)
+    So(string(output), ShouldEqual, "hello\n")
+  })
+
+  Convey("Runs past a job's retention should be removed", t, func() {
+    os.RemoveAll(conf.Attr.LoggingPath)
+    now := time.Now()
+    newest := makeRun("2026-10-17", "Chatty", "cccc", "newest\n", now.Add(-time.Hour))
+    middle := makeRun("2026-10-16", "Chatty", "dddd", "middle\n", now.Add(-25 * time.Hour))
+    oldest := makeRun("2026-10-15", "Chatty", "eeee", "oldest\n", now.Add(-49 * time.Hour))
+    ancient := makeRun("2026-10-01", "Quiet", "ffff", "ancient\n", now.Add(-400 * time.Hour))
+    recent := makeRun("2026-10-17", "Quiet", "gggg", "recent\n", now.Add(-time.Hour))
+
+    jobs := []JobConfig{{Label: "Chatty", KeepRuns: 2}, {Label: "Quiet", KeepDays: 7}}
+    So(HousekeepLogs(conf.Attr.LoggingPath, jobs, map[string]bool{}, now), ShouldEqual, nil)
+    for _, keptPath := range []string{newest, middle, recent} {
+      _, err := os.Stat(keptPath)
+      So(err, ShouldEqual, nil)
+    }
+    for _, removedPath := range []string{oldest, ancient} {
+      _, err := os.Stat(removedPath)
+      So(os.IsNotExist(err), ShouldEqual, true)
+    }
+
+    // Emptied date directories go too
+    _, err := os.Stat(filepath.Join(conf.Attr.LoggingPath, "2026-10-01"))
+    So(os.IsNotExist(err), ShouldEqual, true)
+  })
+
+  Convey("All logs should be held to the daemon's byte limit, oldest first", t, func() {
+    os.RemoveAll(conf.Attr.LoggingPath)
+    logCompress, logMaxBytes := conf.Attr.LogCompress, conf.Attr.LogMaxBytes
+    conf.Attr.LogCompress = false
+    conf.Attr.LogMaxBytes = "1K"
+    defer func() { conf.Attr.LogCompress, conf.Attr.LogMaxBytes = logCompress, logMaxBytes }()
+
+    now := time.Now()
+    older := makeRun("2026-10-17", "Chatty", "hhhh", strings.Repeat("x", 600), now.Add(-2 * time.Hour))
+    newer := makeRun("2026-10-17", "Quiet", "iiii", strings.Repeat("y", 600), now.Add(-time.Hour))
+    So(HousekeepLogs(conf.Attr.LoggingPath, nil, map[string]bool{}, now), ShouldEqual, nil)
+    _, err := os.Stat(older)
+    So(os.IsNotExist(err), ShouldEqual, true)
+    _, err = os.Stat(newer + "/stdout.txt")
+    So(err, ShouldEqual, nil)
+  })
+}
+
+func TestRunLogging(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+
+  Convey("Log lines about a run should carry its label, token and group", t, func() {
+    logged := new(bytes.Buffer)
+    logrus.SetOutput(logged)
+    logrus.SetFormatter(&logrus.JSONFormatter{})
+    conf.Attr.LogJobOutput = true
+    defer func() {
+      logrus.SetOutput(os.Stderr)
+      logrus.SetFormatter(new(logrus.TextFormatter))
+      conf.Attr.LogJobOutput = false
+    }()
+
+    loggedJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Logged", GroupName: "Reports", Command: "echo to out; echo to err >&2", Shell: "/bin/sh -c"},
+      Channel: make(chan ChanComm, 1),
+      StartTime: time.Now()}
+    loggedJob.Run(&running)
+
+    streams := make(map[string]string)
+    for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+      var event map[string]interface{}
+      So(json.Unmarshal([]byte(line), &event), ShouldEqual, nil)
+      So(event["label"], ShouldEqual, "Logged")
+      So(event["token"], ShouldEqual, loggedJob.Token)
+      So(event["group"], ShouldEqual, "Reports")
+      if stream, exists := event["stream"]; exists {
+        streams[stream.(string)] = event["msg"].(string)
+      }
+    }
+    So(streams, ShouldResemble, map[string]string{"stdout": "to out", "stderr": "to err"})
+  })
+}
+
+func TestLogForwarding(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+
+  // listenDatagrams - Stand in for a local logging socket, collecting what is sent to it
+  listenDatagrams := func(socketPath string) (net.PacketConn, chan string) {
+    listener, err := net.ListenPacket("unixgram", socketPath)
+    So(err, ShouldEqual, nil)
+    received := make(chan string, 16)
+    go func() {
+      buf := make([]byte, 64 * 1024)
+      for {
+        n, _, err := listener.ReadFrom(buf)
+        if err != nil {
+          return
+        }
+        received <- string(buf[:n])
+      }
+    }()
+    return listener, received
+  }
+
+  Convey("Unknown targets, facilities and priorities should fail the config check", t, func() {
+    for _, badSyslog := range []SyslogConfig{{To: []string{"loggly"}}, {Facility: "cron2"}, {StdErrPriority: "loud"}} {
+      schedule := JobSchedule{Job: []JobConfig{{Label: "Forwarded", Command: "/bin/true", Schedule: "* * * * *", Syslog: badSyslog}}}
+      So(schedule.CheckConfig(), ShouldNotEqual, nil)
+    }
+    schedule := JobSchedule{Job: []JobConfig{{Label: "Forwarded", Command: "/bin/true", Schedule: "* * * * *", Syslog: SyslogConfig{To: []string{FORWARDSYSLOG, FORWARDJOURNAL}, Facility: "local3", StdErrPriority: "err"}}}}
+    So(schedule.CheckConfig(), ShouldEqual, nil)
+  })
+
+  Convey("Journal fields holding newlines should be length prefixed", t, func() {
+    encoded := formatJournal(logEvent{message: "two\nlines", identifier: "backup", facility: 9, priority: 6})
+    So(string(encoded), ShouldEqual, "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=6\nSYSLOG_FACILITY=9\nSYSLOG_IDENTIFIER=backup\n")
+  })
+
+  Convey("Each output line should reach syslog and the journal with its stream's priority", t, func() {
+    syslogConfig, journalConfig := syslogSocket, journalSocket
+    syslogSocket, journalSocket = scratchDir + "/log", scratchDir + "/journal"
+    defer func() { syslogSocket, journalSocket = syslogConfig, journalConfig }()
+    syslogListener, syslogMessages := listenDatagrams(syslogSocket)
+    defer syslogListener.Close()
+    journalListener, journalMessages := listenDatagrams(journalSocket)
+    defer journalListener.Close()
+
+    forwardedJob := RunningJob{
+      Token: CreateRunToken(),
+      Config: JobConfig{Label: "Forwarded", Command: "echo to err >&2", Shell: "/bin/sh -c", Syslog: SyslogConfig{To: []string{FORWARDSYSLOG, FORWARDJOURNAL}, Identifier: "backup", Facility: "local3"}},
+      Channel: make(chan ChanComm, 1),
+      StartTime: time.Now()}
+    forwardedJob.Run(&running)
+
+    select {
+    case message := <-syslogMessages:
+      // local3 (19) * 8 + warning (4)
+      So(message, ShouldStartWith, "<156>")
+      So(message, ShouldContainSubstring, " backup[" + strconv.Itoa(os.Getpid()) + "]: to err ")
+      So(message, ShouldContainSubstring, "token=" + forwardedJob.Token)
+    case <-time.After(5 * time.Second):
+      So("nothing was sent to syslog", ShouldBeEmpty)
+    }
+    select {
+    case message := <-journalMessages:
+      So(message, ShouldContainSubstring, "MESSAGE=to err\n")
+      So(message, ShouldContainSubstring, "PRIORITY=4\n")
+      So(message, ShouldContainSubstring, "SYSLOG_FACILITY=19\n")
+      So(message, ShouldContainSubstring, "SYSLOG_IDENTIFIER=backup\n")
+      So(message, ShouldContainSubstring, "OMICROND_STREAM=stderr\n")
+      So(message, ShouldContainSubstring, "OMICROND_TOKEN=" + forwardedJob.Token + "\n")
+    case <-time.After(5 * time.Second):
+      So("nothing was sent to the journal", ShouldBeEmpty)
+    }
+  })
+
+  Convey("The daemon's log should be forwarded with its level as the priority", t, func() {
+    journalConfig := journalSocket
+    journalSocket = scratchDir + "/daemon-journal"
+    defer func() { journalSocket = journalConfig }()
+    journalListener, journalMessages := listenDatagrams(journalSocket)
+    defer journalListener.Close()
+
+    forwardHook, err := NewForwardHook([]string{FORWARDJOURNAL})
+    So(err, ShouldEqual, nil)
+    logger := logrus.New()
+    logger.Out = ioutil.Discard
+    logger.Hooks.Add(forwardHook)
+    logger.WithField("label", "Forwarded").Error("could not start")
+
+    select {
+    case message := <-journalMessages:
+      So(message, ShouldContainSubstring, "MESSAGE=could not start\n")
+      So(message, ShouldContainSubstring, "PRIORITY=3\n")
+      So(message, ShouldContainSubstring, "SYSLOG_IDENTIFIER=omicrond\n")
+      So(message, ShouldContainSubstring, "OMICROND_LABEL=Forwarded\n")
+    case <-time.After(5 * time.Second):
+      So("nothing was sent to the journal", ShouldBeEmpty)
+    }
+  })
+}
+
+func TestJobDependencies(t *testing.T) {
+
+  extract := JobConfig{Label: "Extract", Command: "/bin/true", Schedule: "0 1 * * *"}
+  transform := JobConfig{Label: "Transform", Command: "/bin/true",
+    DependsOn: []JobDependency{{Label: "Extract", ReturnCodes: []int{0, 3}, MaxAge: "1h"}}}
+  load := JobConfig{Label: "Load", Command: "/bin/true", DependsOn: []JobDependency{{Label: "Transform"}}}
+
+  schedule := JobSchedule{Job: []JobConfig{extract, transform, load}}
+  Convey("A dependency chain without cycles should pass the config check", t, func() {
+    So(schedule.CheckConfig(), ShouldEqual, nil)
+    So(schedule.GetDownstreamJobs("Extract"), ShouldResemble, []int{1})
+  })
+
+  cyclic := JobSchedule{Job: []JobConfig{extract, transform, load}}
+  cyclic.Job[0].DependsOn = []JobDependency{{Label: "Load"}}
+  Convey("A dependency cycle should fail the config check", t, func() {
+    So(cyclic.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  unknown := JobSchedule{Job: []JobConfig{transform}}
+  Convey("A dependency on an unknown job should fail the config check", t, func() {
+    So(unknown.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("A job without a schedule should only run when triggered by its dependencies", t, func() {
+    So(schedule.Job[1].CheckIfScheduled(time.Now()), ShouldEqual, false)
+  })
+
+  now := time.Now()
+  Convey("Dependencies should be met by a recent accepted return code", t, func() {
+    results := map[string]JobResult{"Extract": {Label: "Extract", ExitCode: 3, EndTime: now.Add(-time.Minute)}}
+    So(schedule.Job[1].DependenciesMet(results, time.Time{}, now), ShouldEqual, true)
+  })
+  Convey("Dependencies should not be met by an unaccepted return code", t, func() {
+    results := map[string]JobResult{"Extract": {Label: "Extract", ExitCode: 1, EndTime: now.Add(-time.Minute)}}
+    So(schedule.Job[1].DependenciesMet(results, time.Time{}, now), ShouldEqual, false)
+  })
+  Convey("Dependencies should not be met by a run older than maxAge", t, func() {
+    results := map[string]JobResult{"Extract": {Label: "Extract", ExitCode: 0, EndTime: now.Add(-2 * time.Hour)}}
+    So(schedule.Job[1].DependenciesMet(results, time.Time{}, now), ShouldEqual, false)
+  })
+  Convey("Dependencies should not be met twice by the same upstream run", t, func() {
+    results := map[string]JobResult{"Extract": {Label: "Extract", ExitCode: 0, EndTime: now.Add(-time.Minute)}}
+    So(schedule.Job[1].DependenciesMet(results, now, now), ShouldEqual, false)
+  })
+
+  Convey("Dependency strings from the API should be parsed", t, func() {
+    dependency, err := ParseDependencyString("Extract:0,3:12h")
+    So(err, ShouldEqual, nil)
+    So(dependency.Label, ShouldEqual, "Extract")
+    So(dependency.ReturnCodes, ShouldResemble, []int{0, 3})
+    So(dependency.MaxAge, ShouldEqual, "12h")
+    _, err = ParseDependencyString("Extract:zero")
+    So(err, ShouldNotEqual, nil)
+  })
+}
+
+func TestRunHistory(t *testing.T) {
+
+  // Keep logs and history in a scratch directory
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  conf.Attr.LoggingPath = scratchDir + "/logs"
+  historyPath := scratchDir + "/history.jsonl"
+
+  // Run a job that fails and record it
+  running := RunningJobTracker{Sync: new(sync.RWMutex), Jobs: make(map[string]RunningJob)}
+  failingJob := RunningJob{
+    Token: CreateRunToken(),
+    Config: JobConfig{Label: "Failing Job", Command: "/bin/false"},
+    Channel: make(chan ChanComm),
+    StartTime: time.Now()}
+  failingJob.Run(&running)
+  result := failingJob.Result()
+
+  Convey("A failed run should report its return code and log locations", t, func() {
+    So(result.ExitCode, ShouldEqual, 1)
+    So(result.Status, ShouldEqual, STATUSFAILED)
+    So(result.EndTime.IsZero(), ShouldEqual, false)
+    So(result.StdOutPath, ShouldStartWith, conf.Attr.LoggingPath)
+  })
+
+  err := AppendRunHistory(historyPath, result)
+  Convey("The run should be appended to the history file", t, func() {
+    So(err, ShouldEqual, nil)
+  })
+  AppendRunHistory(historyPath, JobResult{Token: "0123456789abcdef", Label: "Other Job"})
+
+  Convey("The run should be found by label and token", t, func() {
+    byLabel, err := GetRunHistoryByLabel(historyPath, "Failing_Job")
+    So(err, ShouldEqual, nil)
+    So(len(byLabel), ShouldEqual, 1)
+    So(byLabel[0].Token, ShouldEqual, failingJob.Token)
+    byToken, err := GetRunHistoryByToken(historyPath, failingJob.Token)
+    So(err, ShouldEqual, nil)
+    So(len(byToken), ShouldEqual, 1)
+    So(byToken[0].ExitCode, ShouldEqual, 1)
+  })
+
+  Convey("A missing history file should read as empty", t, func() {
+    runs, err := ReadRunHistory(scratchDir + "/missing.jsonl", nil)
+    So(err, ShouldEqual, nil)
+    So(len(runs), ShouldEqual, 0)
+  })
+
+  Convey("Trimming should keep each job's newest runs and its last success", t, func() {
+    trimPath := scratchDir + "/trim.jsonl"
+    now := time.Now()
+    AppendRunHistory(trimPath, JobResult{Token: "old", Label: "Flaky", Status: STATUSSUCCEEDED, EndTime: now.Add(-72 * time.Hour)})
+    for runIndex := 0; runIndex < 5; runIndex++ {
+      AppendRunHistory(trimPath, JobResult{Token: strconv.Itoa(runIndex), Label: "Flaky", Status: STATUSFAILED, EndTime: now})
+    }
+    AppendRunHistory(trimPath, JobResult{Token: "ancient", Label: "Rare", Status: STATUSFAILED, EndTime: now.Add(-72 * time.Hour)})
+    So(TrimRunHistory(trimPath, 2, 1, now), ShouldEqual, nil)
+
+    runs, err := ReadRunHistory(trimPath, nil)
+    So(err, ShouldEqual, nil)
+    tokens := []string{}
+    for _, run := range runs {
+      tokens = append(tokens, run.Token)
+    }
+    So(tokens, ShouldResemble, []string{"old", "3", "4", "ancient"})
+    lastSuccesses, _ := GetLastSuccessTimes(trimPath)
+    So(lastSuccesses["Flaky"].Equal(now.Add(-72 * time.Hour)), ShouldEqual, true)
+
+    So(TrimRunHistory(scratchDir + "/missing.jsonl", 2, 1, now), ShouldEqual, nil)
+  })
+}
+
+func TestFollowFile(t *testing.T) {
+
+  scratchDir, _ := ioutil.TempDir("", "omicrond")
+  defer os.RemoveAll(scratchDir)
+  logPath := scratchDir + "/stdout.txt"
+
+  // Write the log in two parts while the "job" is running
+  running := true
+  var runningSync sync.Mutex
+  go func() {
+    time.Sleep(100 * time.Millisecond)
+    ioutil.WriteFile(logPath, []byte("first\n"), 0644)
+    time.Sleep(followPollInterval)
+    logFile, _ := os.OpenFile(logPath, os.O_APPEND | os.O_WRONLY, 0644)
+    logFile.WriteString("second\n")
+    logFile.Close()
+    time.Sleep(followPollInterval)
+    runningSync.Lock()
+    running = false
+    runningSync.Unlock()
+  }()
+
+  var output bytes.Buffer
+  err := FollowFile(context.Background(), logPath, 0, &output, func() (bool) {
+    runningSync.Lock()
+    defer runningSync.Unlock()
+    return running
+  })
+
+  Convey("Following a log should copy everything written until the job stops", t, func() {
+    So(err, ShouldEqual, nil)
+    So(output.String(), ShouldEqual, "first\nsecond\n")
+  })
+}
+
+func TestDryRun(t *testing.T) {
+
+  sleeper := JobConfig{Label: "Sleeper", Command: "/bin/sleep 5", Schedule: "* * * * *"}
+
+  Convey("A dry run without execution should only validate the job", t, func() {
+    result, err := sleeper.DryRun(nil, nil, false, time.Second)
+    So(err, ShouldEqual, nil)
+    So(result.Executed, ShouldEqual, false)
+    So(result.Args, ShouldResemble, []string{"5"})
+  })
+
+  Convey("A dry run should be killed when it outlives its timeout", t, func() {
+    result, err := sleeper.DryRun(nil, nil, true, 200 * time.Millisecond)
+    So(err, ShouldEqual, nil)
+    So(result.TimedOut, ShouldEqual, true)
+    So(result.Signal, ShouldEqual, "killed")
+  })
+
+  Convey("A dry run of a missing executable should fail validation", t, func() {
+    missing := JobConfig{Label: "Missing", Command: "/nonexistent/omicrond-test", Schedule: "* * * * *"}
+    _, err := missing.DryRun(nil, nil, true, time.Second)
+    So(err, ShouldNotEqual, nil)
+  })
+}
+
+func TestJobGroups(t *testing.T) {
+
+  var schedule JobSchedule
+  err := schedule.ParseJobConfig("../sample/sampleJobConf.toml")
+  group, isGrouped := schedule.GetGroup("Test")
+
+  Convey("Groups should be read from the [[group]] tables of the config", t, func() {
+    So(err, ShouldEqual, nil)
+    So(isGrouped, ShouldEqual, true)
+    So(group.StopWindow, ShouldEqual, "5m")
+    So(group.MaxConcurrent, ShouldEqual, 2)
+  })
+
+  Convey("Evenly spread jobs should be placed at equal steps across the window", t, func() {
+    So(group.StaggerDelays(5), ShouldResemble, []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute})
+  })
+
+  Convey("Randomly spread jobs should land inside the window", t, func() {
+    random := GroupConfig{Name: "Random", StartWindow: "1m", StopWindow: "2m", Spread: SPREADRANDOM}
+    for _, delay := range random.StaggerDelays(20) {
+      So(delay, ShouldBeGreaterThanOrEqualTo, time.Minute)
+      So(delay, ShouldBeLessThan, 2 * time.Minute)
+    }
+  })
+
+  Convey("Groups with a backwards window should fail the config check", t, func() {
+    backwards := JobSchedule{Group: []GroupConfig{{Name: "Backwards", StartWindow: "5m", StopWindow: "1m"}}}
+    So(backwards.CheckConfig(), ShouldNotEqual, nil)
+  })
+
+  Convey("Groups should survive being written back to the config file", t, func() {
+    scratchDir, _ := ioutil.TempDir("", "omicrond")
+    defer os.RemoveAll(scratchDir)
+    So(schedule.WriteJobConfig(scratchDir + "/jobs.toml"), ShouldEqual, nil)
+    var rewritten JobSchedule
+    So(rewritten.ParseJobConfig(scratchDir + "/jobs.toml"), ShouldEqual, nil)
+    So(rewritten.Group, ShouldResemble, schedule.Group)
+  })
+}
+
+func TestScheduleResolution(t *testing.T) {
+
+  minutely := JobConfig{Label: "Minutely", Command: "/bin/date", Schedule: "* * * * *"}
+  probe := JobConfig{Label: "Probe", Command: "/bin/date", Schedule: "*/15 * * * * *"}
+
+  Convey("Five field schedules should be checked every minute", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{minutely}}
+    So(schedule.Resolution(), ShouldEqual, time.Minute)
+    So(minutely.HasSecondsField(), ShouldEqual, false)
+  })
+
+  Convey("Any six field schedule should have the schedule checked every second", t, func() {
+    schedule := JobSchedule{Job: []JobConfig{minutely, probe}}
+    So(schedule.Resolution(), ShouldEqual, time.Second)
+    So(probe.HasSecondsField(), ShouldEqual, true)
+  })
+
+  Convey("Five field schedules should be checked at the minute even when the loop reaches it late", t, func() {
+    minute := time.Date(2017, 3, 14, 9, 26, 0, 0, time.Local)
+    checkTime, isChecked := minutely.CheckTime(minute.Add(-time.Second), minute.Add(time.Second))
+    So(isChecked, ShouldEqual, true)
+    So(checkTime, ShouldResemble, minute)
+    _, isChecked = minutely.CheckTime(minute.Add(time.Second), minute.Add(2 * time.Second))
+    So(isChecked, ShouldEqual, false)
+    checkTime, isChecked = probe.CheckTime(minute, minute.Add(time.Second))
+    So(isChecked, ShouldEqual, true)
+    So(checkTime, ShouldResemble, minute.Add(time.Second))
+  })
+
+  Convey("Schedules with seven fields should not parse", t, func() {
+    tooLong := JobConfig{Label: "Too Long", Command: "/bin/date", Schedule: "0 * * * * * *"}
+    So(tooLong.ParseScheduleIntoFilters(true), ShouldNotEqual, nil)
+  })
+}
@@ -0,0 +1,338 @@
+package job
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "errors"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/smtp"
+  "net/url"
+  "os"
+  "os/exec"
+  "strconv"
+  "strings"
+  "syscall"
+  "text/template"
+  "time"
+  "github.com/Sirupsen/logrus"
+  "github.com/brysearl/omicrond/conf"
+)
+
+const (
+  NOTIFYSMTP = "smtp"
+  NOTIFYWEBHOOK = "webhook"
+  NOTIFYCOMMAND = "command"
+)
+
+const (
+  NOTIFYFAILURE = "failure"
+  NOTIFYSUCCESS = "success"
+  NOTIFYTIMEOUT = "timeout"
+  NOTIFYOUTPUT = "output"
+)
+
+// notifyTimeout - How long a webhook or notify command may take before it is given up on
+const notifyTimeout = time.Minute
+
+// notifyOutputLimit - Bytes of each output stream included in notifications
+const notifyOutputLimit = 64 * 1024
+
+// defaultNotifySubject - Subject of notification emails without a Subject template
+const defaultNotifySubject = "[omicrond] {{.Label}} {{.Status}} on {{.Host}}"
+
+// defaultNotifyText - Body of notification emails without a Body template
+const defaultNotifyText = `Job:       {{.Label}}
+Status:    {{.Status}}
+Exit code: {{.ExitCode}}{{if .Signal}}
+Signal:    {{.Signal}}{{end}}
+Trigger:   {{.Trigger}}
+Token:     {{.Token}}
+Attempt:   {{.Attempt}}
+Host:      {{.Host}}
+Started:   {{.StartTime}}
+Ended:     {{.EndTime}}
+{{if .StdOut}}
+STDOUT:
+{{.StdOut}}{{end}}{{if .StdErr}}
+STDERR:
+{{.StdErr}}{{end}}
+`
+
+// NotifyRule - Where to send word of a finished run, and which outcomes are worth it
+type NotifyRule struct {
+  On      []string               // Outcomes that fire the rule: failure (anything but success), success, timeout or output
+  Type    string                 // Backend: smtp, webhook or command
+  To      []string               // smtp: Addresses to mail
+  Subject string                 // smtp: Subject template.  Defaults to '[omicrond] <label> <status> on <host>'
+  URL     string                 // webhook: Address the notification is POSTed to
+  Body    string                 // smtp and webhook: Body template.  Webhooks default to the message as JSON
+  Command string                 // command: Run with /bin/sh -c, with the message as JSON on stdin
+}
+
+// NotifyMessage - What templates and backends know about the run.  Templates may use '{{json .Field}}' to quote
+//  values for a JSON body
+type NotifyMessage struct {
+  JobResult
+  Host   string                  // Name of the host the job ran on
+  StdOut string                  // Start of the run's standard output
+  StdErr string                  // Start of the run's standard error
+}
+
+// notifyTemplateFuncs - Helpers available to notification templates
+var notifyTemplateFuncs = template.FuncMap{
+  "json": func(value interface{}) (string, error) {
+    encoded, err := json.Marshal(value)
+    return string(encoded), err
+  },
+}
+
+// checkNotifiers - Make sure every notify rule has a known backend, outcomes and what its backend needs
+func (j *JobConfig) checkNotifiers() (error) {
+
+  for ruleIndex, _ := range j.Notify {
+    rule := &j.Notify[ruleIndex]
+    prefix := "Config error: Job [" + j.Label + "] notify rule " + strconv.Itoa(ruleIndex + 1)
+    if len(rule.On) == 0 {
+      return errors.New(prefix + " needs at least one outcome to fire on")
+    }
+    for _, outcome := range rule.On {
+      if outcome != NOTIFYFAILURE && outcome != NOTIFYSUCCESS && outcome != NOTIFYTIMEOUT && outcome != NOTIFYOUTPUT {
+        return errors.New(prefix + " outcomes must be failure, success, timeout or output: " + outcome)
+      }
+    }
+
+    switch rule.Type {
+    case NOTIFYSMTP:
+      if len(rule.To) == 0 {
+        return errors.New(prefix + " needs addresses to mail")
+      }
+    case NOTIFYWEBHOOK:
+      if parsedURL, err := url.Parse(rule.URL); err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+        return errors.New(prefix + " needs an http or https URL: " + rule.URL)
+      }
+    case NOTIFYCOMMAND:
+      if rule.Command == "" {
+        return errors.New(prefix + " needs a command to run")
+      }
+    default:
+      return errors.New(prefix + " type must be smtp, webhook or command: " + rule.Type)
+    }
+
+    for _, rawTemplate := range []string{rule.Subject, rule.Body} {
+      if _, err := template.New("notify").Funcs(notifyTemplateFuncs).Parse(rawTemplate); err != nil {
+        return errors.New(prefix + " has a bad template: " + err.Error())
+      }
+    }
+  }
+
+  return nil
+}
+
+// Matches - Whether the finished run is one of the outcomes the rule fires on
+func (rule *NotifyRule) Matches(message NotifyMessage) (bool) {
+
+  for _, outcome := range rule.On {
+    switch outcome {
+    case NOTIFYFAILURE:
+      if message.Status != STATUSSUCCEEDED {
+        return true
+      }
+    case NOTIFYSUCCESS:
+      if message.Status == STATUSSUCCEEDED {
+        return true
+      }
+    case NOTIFYTIMEOUT:
+      if message.TimedOut {
+        return true
+      }
+    case NOTIFYOUTPUT:
+      if message.StdOut != "" || message.StdErr != "" {
+        return true
+      }
+    }
+  }
+
+  return false
+}
+
+// send - Deliver the message through the rule's backend.  Commands run with the job's credential and environment
+func (rule *NotifyRule) send(message NotifyMessage, credential *syscall.Credential, env []string) (error) {
+
+  switch rule.Type {
+  case NOTIFYSMTP:
+    return rule.sendMail(message)
+  case NOTIFYWEBHOOK:
+    return rule.postWebhook(message)
+  case NOTIFYCOMMAND:
+    return rule.runCommand(message, credential, env)
+  }
+
+  return errors.New("Unknown notifier type: " + rule.Type)
+}
+
+// Notify - Send word of the finished run to every rule it matches.  Called once the run's last attempt is done, even
+//  if a stop cancelled its retries, so attempts that get retried stay quiet.  Notifications go out in the background
+//  so a slow backend never holds up the job
+func (r *RunningJob) Notify() {
+
+  if len(r.Config.Notify) == 0 {
+    return
+  }
+  result := r.Result()
+
+  message := NotifyMessage{JobResult: result}
+  message.Host, _ = os.Hostname()
+  message.StdOut = readOutputStart(result.StdOutPath)
+  message.StdErr = readOutputStart(result.StdErrPath)
+
+  // Notify commands come from the job's config, so they get no more rights than the job itself and see the same
+  //  environment it did
+  credential, loginEnv, err := r.Config.credential()
+  if err != nil {
+    r.Logger().Error("[" + message.Label + "] cannot notify: " + err.Error())
+    return
+  }
+  env, err := r.Config.environment(loginEnv, r.ExtraEnv)
+  if err != nil {
+    r.Logger().Error("[" + message.Label + "] cannot notify: " + err.Error())
+    return
+  }
+  if env == nil {
+    env = os.Environ()
+  }
+
+  for ruleIndex, _ := range r.Config.Notify {
+    rule := r.Config.Notify[ruleIndex]
+    if rule.Matches(message) == false {
+      continue
+    }
+    go func(rule NotifyRule, logger *logrus.Entry) {
+      if err := rule.send(message, credential, env); err != nil {
+        logger.Error("[" + message.Label + "] " + rule.Type + " notification failed: " + err.Error())
+      }
+    }(rule, r.Logger())
+  }
+}
+
+// readOutputStart - The start of an output file, trimmed to notifyOutputLimit.  Empty if it can't be read
+func readOutputStart(outputPath string) (string) {
+
+  outputFile, err := os.Open(outputPath)
+  if err != nil {
+    return ""
+  }
+  defer outputFile.Close()
+  output, _ := ioutil.ReadAll(io.LimitReader(outputFile, notifyOutputLimit))
+
+  return string(output)
+}
+
+// render - Fill a notification template with the message, falling back to defaultTemplate when it is empty
+func (message *NotifyMessage) render(rawTemplate string, defaultTemplate string) (string, error) {
+
+  if rawTemplate == "" {
+    rawTemplate = defaultTemplate
+  }
+  parsedTemplate, err := template.New("notify").Funcs(notifyTemplateFuncs).Parse(rawTemplate)
+  if err != nil {
+    return "", err
+  }
+  var rendered bytes.Buffer
+  err = parsedTemplate.Execute(&rendered, message)
+
+  return rendered.String(), err
+}
+
+// sendMail - Mail the message through the daemon's SMTP relay
+func (rule *NotifyRule) sendMail(message NotifyMessage) (error) {
+
+  subject, err := message.render(rule.Subject, defaultNotifySubject)
+  if err != nil {
+    return err
+  }
+  body, err := message.render(rule.Body, defaultNotifyText)
+  if err != nil {
+    return err
+  }
+
+  mail := "From: " + conf.Attr.SMTPFrom + "\r\n" +
+    "To: " + strings.Join(rule.To, ", ") + "\r\n" +
+    "Subject: " + strings.Replace(strings.Replace(subject, "\r", "", -1), "\n", " ", -1) + "\r\n" +
+    "Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+    "Content-Type: text/plain; charset=UTF-8\r\n" +
+    "\r\n" + strings.Replace(body, "\n", "\r\n", -1)
+
+  var auth smtp.Auth
+  if conf.Attr.SMTPUser != "" {
+    host := conf.Attr.SMTPAddress
+    if colon := strings.LastIndex(host, ":"); colon >= 0 {
+      host = host[:colon]
+    }
+    auth = smtp.PlainAuth("", conf.Attr.SMTPUser, conf.Attr.SMTPPassword, host)
+  }
+
+  return smtp.SendMail(conf.Attr.SMTPAddress, auth, conf.Attr.SMTPFrom, rule.To, []byte(mail))
+}
+
+// postWebhook - POST the rendered body, or the message as JSON, to the rule's URL
+func (rule *NotifyRule) postWebhook(message NotifyMessage) (error) {
+
+  var body string
+  if rule.Body == "" {
+    encoded, err := json.Marshal(message)
+    if err != nil {
+      return err
+    }
+    body = string(encoded)
+  } else {
+    var err error
+    body, err = message.render(rule.Body, "")
+    if err != nil {
+      return err
+    }
+  }
+
+  client := &http.Client{Timeout: notifyTimeout}
+  response, err := client.Post(rule.URL, "application/json", strings.NewReader(body))
+  if err != nil {
+    return err
+  }
+  defer response.Body.Close()
+  if response.StatusCode < 200 || response.StatusCode > 299 {
+    return errors.New("Webhook " + rule.URL + " returned " + response.Status)
+  }
+
+  return nil
+}
+
+// runCommand - Run the rule's command with the message as JSON on stdin and its main fields added to the job's
+//  environment
+func (rule *NotifyRule) runCommand(message NotifyMessage, credential *syscall.Credential, env []string) (error) {
+
+  encoded, err := json.Marshal(message)
+  if err != nil {
+    return err
+  }
+
+  ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+  defer cancel()
+  cmd := exec.CommandContext(ctx, "/bin/sh", "-c", rule.Command)
+  cmd.Stdin = bytes.NewReader(encoded)
+  cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+  cmd.Env = append(append([]string(nil), env...),
+    "OMICROND_LABEL=" + message.Label,
+    "OMICROND_TOKEN=" + message.Token,
+    "OMICROND_STATUS=" + message.Status,
+    "OMICROND_EXIT_CODE=" + strconv.Itoa(message.ExitCode),
+    "OMICROND_STDOUT=" + message.StdOutPath,
+    "OMICROND_STDERR=" + message.StdErrPath)
+  output, err := cmd.CombinedOutput()
+  if err != nil {
+    return errors.New(err.Error() + ": " + strings.TrimSpace(string(output)))
+  }
+
+  return nil
+}
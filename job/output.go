@@ -0,0 +1,65 @@
+package job
+
+import (
+  "context"
+  "io"
+  "os"
+  "time"
+)
+
+// followPollInterval - How often a followed log file is checked for new output
+const followPollInterval = 500 * time.Millisecond
+
+// FollowFile - Copy a log file to the writer starting at offset, then keep copying new output as it is written
+//  until isRunning reports the job is done or the context is cancelled.  The file may not exist yet when a job
+//  has only just started.
+func FollowFile(ctx context.Context, logPath string, offset int64, w io.Writer, isRunning func() (bool)) (error) {
+
+  var logFile *os.File
+  buf := make([]byte, 32 * 1024)
+  for {
+
+    // Check liveness before reading so the final read always catches the last of the output
+    running := isRunning()
+
+    if logFile == nil {
+      var err error
+      logFile, err = os.Open(logPath)
+      if err == nil {
+        defer logFile.Close()
+        if _, err := logFile.Seek(offset, io.SeekStart); err != nil {
+          return err
+        }
+      } else if !os.IsNotExist(err) {
+        return err
+      }
+    }
+
+    // Copy everything written since the last pass
+    if logFile != nil {
+      for {
+        n, err := logFile.Read(buf)
+        if n > 0 {
+          if _, err := w.Write(buf[:n]); err != nil {
+            return err
+          }
+        }
+        if err == io.EOF {
+          break
+        } else if err != nil {
+          return err
+        }
+      }
+    }
+
+    if running == false {
+      return nil
+    }
+
+    select {
+    case <-ctx.Done():
+      return ctx.Err()
+    case <-time.After(followPollInterval):
+    }
+  }
+}
@@ -0,0 +1,179 @@
+package job
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "syscall"
+  "time"
+)
+
+// procRoot - Where the kernel's process information is mounted
+var procRoot = "/proc"
+
+// clockTicks - Units of the CPU times in /proc/<pid>/stat.  USER_HZ is 100 on every linux platform we run on
+const clockTicks = 100
+
+// ProcessStats - Resources the whole process tree of a run is using right now
+type ProcessStats struct {
+  Children   int                 // Processes in the tree besides the command itself
+  RSS        int64               // Resident memory in bytes
+  UserTime   time.Duration       // CPU time spent in user mode, including children already reaped
+  SystemTime time.Duration       // CPU time spent in the kernel, including children already reaped
+  ReadBytes  int64               // Bytes read from storage
+  WriteBytes int64               // Bytes written to storage
+}
+
+// RunUsage - Resources the command and the children it waited on used over the whole run
+type RunUsage struct {
+  UserTime   time.Duration       // CPU time spent in user mode
+  SystemTime time.Duration       // CPU time spent in the kernel
+  MaxRSS     int64               // Largest resident memory of any single process, in bytes
+  InBlocks   int64               // Blocks read from storage
+  OutBlocks  int64               // Blocks written to storage
+}
+
+// procStat - The fields of /proc/<pid>/stat we use
+type procStat struct {
+  pid     int
+  ppid    int
+  session int
+  utime   int64                  // Clock ticks, including reaped children
+  stime   int64                  // Clock ticks, including reaped children
+  rss     int64                  // Pages
+}
+
+// readProcStat - Parse /proc/<pid>/stat.  The command name is skipped past its last ')' since it may hold anything
+func readProcStat(procPath string, pid int) (procStat, error) {
+
+  rawStat, err := ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+  if err != nil {
+    return procStat{}, err
+  }
+  commEnd := strings.LastIndex(string(rawStat), ")")
+  if commEnd < 0 {
+    return procStat{}, os.ErrInvalid
+  }
+
+  // Fields after the command start at 'state', the third field of the file
+  fields := strings.Fields(string(rawStat)[commEnd + 1:])
+  if len(fields) < 22 {
+    return procStat{}, os.ErrInvalid
+  }
+  stat := procStat{pid: pid}
+  stat.ppid, _ = strconv.Atoi(fields[1])
+  stat.session, _ = strconv.Atoi(fields[3])
+  utime, _ := strconv.ParseInt(fields[11], 10, 64)
+  stime, _ := strconv.ParseInt(fields[12], 10, 64)
+  cutime, _ := strconv.ParseInt(fields[13], 10, 64)
+  cstime, _ := strconv.ParseInt(fields[14], 10, 64)
+  stat.utime = utime + cutime
+  stat.stime = stime + cstime
+  stat.rss, _ = strconv.ParseInt(fields[21], 10, 64)
+
+  return stat, nil
+}
+
+// readProcIO - Bytes read from and written to storage by a process.  Zero when /proc/<pid>/io can't be read
+func readProcIO(procPath string, pid int) (int64, int64) {
+
+  rawIO, err := ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "io"))
+  if err != nil {
+    return 0, 0
+  }
+  var readBytes, writeBytes int64
+  for _, line := range strings.Split(string(rawIO), "\n") {
+    fields := strings.Fields(line)
+    if len(fields) != 2 {
+      continue
+    }
+    if fields[0] == "read_bytes:" {
+      readBytes, _ = strconv.ParseInt(fields[1], 10, 64)
+    } else if fields[0] == "write_bytes:" {
+      writeBytes, _ = strconv.ParseInt(fields[1], 10, 64)
+    }
+  }
+
+  return readBytes, writeBytes
+}
+
+// readProcessTree - Add up the resources of the command and everything it spawned.  That is every descendant, plus
+//  anything still in the command's session after being orphaned
+func readProcessTree(procPath string, rootPid int) (ProcessStats) {
+
+  var stats ProcessStats
+  entries, err := ioutil.ReadDir(procPath)
+  if err != nil {
+    return stats
+  }
+
+  allStats := make(map[int]procStat)
+  children := make(map[int][]int)
+  for _, entry := range entries {
+    pid, err := strconv.Atoi(entry.Name())
+    if err != nil {
+      continue
+    }
+    stat, err := readProcStat(procPath, pid)
+    if err != nil {
+      continue
+    }
+    allStats[pid] = stat
+    children[stat.ppid] = append(children[stat.ppid], pid)
+  }
+  if _, exists := allStats[rootPid]; exists == false {
+    return stats
+  }
+
+  inTree := map[int]bool{rootPid: true}
+  toVisit := []int{rootPid}
+  for len(toVisit) > 0 {
+    pid := toVisit[0]
+    toVisit = toVisit[1:]
+    for _, childPid := range children[pid] {
+      if inTree[childPid] == false {
+        inTree[childPid] = true
+        toVisit = append(toVisit, childPid)
+      }
+    }
+  }
+  for pid, stat := range allStats {
+    if stat.session == rootPid {
+      inTree[pid] = true
+    }
+  }
+
+  pageSize := int64(os.Getpagesize())
+  for pid, _ := range inTree {
+    stat := allStats[pid]
+    if pid != rootPid {
+      stats.Children++
+    }
+    stats.RSS += stat.rss * pageSize
+    stats.UserTime += time.Duration(stat.utime) * time.Second / clockTicks
+    stats.SystemTime += time.Duration(stat.stime) * time.Second / clockTicks
+    readBytes, writeBytes := readProcIO(procPath, pid)
+    stats.ReadBytes += readBytes
+    stats.WriteBytes += writeBytes
+  }
+
+  return stats
+}
+
+// runUsage - Resources used by a finished command, from its ProcessState.  Linux reports maxrss in kilobytes
+func runUsage(state *os.ProcessState) (RunUsage) {
+
+  if state == nil {
+    return RunUsage{}
+  }
+  usage := RunUsage{UserTime: state.UserTime(), SystemTime: state.SystemTime()}
+  if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+    usage.MaxRSS = int64(rusage.Maxrss) * 1024
+    usage.InBlocks = int64(rusage.Inblock)
+    usage.OutBlocks = int64(rusage.Oublock)
+  }
+
+  return usage
+}
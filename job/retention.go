@@ -0,0 +1,397 @@
+package job
+
+import (
+  "compress/gzip"
+  "errors"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "github.com/Sirupsen/logrus"
+  "github.com/brysearl/omicrond/conf"
+)
+
+// logFileNames - Output files written into each run's log directory
+var logFileNames = []string{"stdout.txt", "stderr.txt"}
+
+// checkRetention - Make sure the output limit and retention policy make sense
+func (j *JobConfig) checkRetention() (error) {
+
+  if j.MaxOutput != "" {
+    if _, err := parseByteSize(j.MaxOutput); err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] maxOutput " + err.Error())
+    }
+  }
+  if j.MaxLogBytes != "" {
+    if _, err := parseByteSize(j.MaxLogBytes); err != nil {
+      return errors.New("Config error: Job [" + j.Label + "] maxLogBytes " + err.Error())
+    }
+  }
+  if j.KeepRuns < 0 {
+    return errors.New("Config error: Job [" + j.Label + "] keepRuns cannot be negative: " + strconv.Itoa(j.KeepRuns))
+  }
+  if j.KeepDays < 0 {
+    return errors.New("Config error: Job [" + j.Label + "] keepDays cannot be negative: " + strconv.Itoa(j.KeepDays))
+  }
+
+  return nil
+}
+
+// OutputLimit - Most bytes of each output stream written to a run's log, 0 for unlimited.  Falls back on the daemon's
+//  LogMaxOutput
+func (j *JobConfig) OutputLimit() (int64) {
+
+  maxOutput := j.MaxOutput
+  if maxOutput == "" {
+    maxOutput = conf.Attr.LogMaxOutput
+  }
+  if maxOutput == "" {
+    return 0
+  }
+  limit, err := parseByteSize(maxOutput)
+  if err != nil {
+    j.Logger().Error("Ignoring output limit of [" + j.Label + "]: " + err.Error())
+    return 0
+  }
+
+  return limit
+}
+
+// outputLog - Log file of one output stream that stops growing at a byte limit.  What is dropped is counted so a
+//  marker can say how much is missing
+type outputLog struct {
+  file    *os.File
+  limit   int64                // 0 is unlimited
+  written int64
+  dropped int64
+}
+
+// createOutputLog - Create the log file of an output stream
+func createOutputLog(logPath string, limit int64) (*outputLog, error) {
+
+  logFile, err := os.Create(logPath)
+  if err != nil {
+    return nil, err
+  }
+
+  return &outputLog{file: logFile, limit: limit}, nil
+}
+
+// WriteLine - Write a line of output, or as much of it as fits under the limit
+func (o *outputLog) WriteLine(line string) {
+
+  line += "\n"
+  if o.limit > 0 && o.written + int64(len(line)) > o.limit {
+    room := o.limit - o.written
+    if room < 0 {
+      room = 0
+    }
+    o.dropped += int64(len(line)) - room
+    line = line[:room]
+  }
+  if line == "" {
+    return
+  }
+  n, _ := o.file.WriteString(line)
+  o.written += int64(n)
+}
+
+// Close - Mark the log as truncated if anything was dropped, then close it
+func (o *outputLog) Close() (error) {
+
+  if o.dropped > 0 {
+    o.file.WriteString("\n[omicrond: output truncated at " + strconv.FormatInt(o.limit, 10) + " bytes, " + strconv.FormatInt(o.dropped, 10) + " bytes dropped]\n")
+  }
+
+  return o.file.Close()
+}
+
+// compressedLog - A compressed log decompressed as it is read
+type compressedLog struct {
+  *gzip.Reader
+  file *os.File
+}
+
+// Close - Close both the decompressor and the file under it
+func (c compressedLog) Close() (error) {
+
+  c.Reader.Close()
+
+  return c.file.Close()
+}
+
+// OpenCompressedLog - Open a run's log that housekeeping has compressed.  It is decompressed as it is read, so it can
+//  only be read from the start.  The compressed file's modification time is returned alongside
+func OpenCompressedLog(logPath string) (io.ReadCloser, time.Time, error) {
+
+  compressedFile, err := os.Open(logPath + ".gz")
+  if err != nil {
+    return nil, time.Time{}, err
+  }
+  info, err := compressedFile.Stat()
+  if err != nil {
+    compressedFile.Close()
+    return nil, time.Time{}, err
+  }
+  reader, err := gzip.NewReader(compressedFile)
+  if err != nil {
+    compressedFile.Close()
+    return nil, time.Time{}, err
+  }
+
+  return compressedLog{Reader: reader, file: compressedFile}, info.ModTime(), nil
+}
+
+// runLogs - The log directory of one finished run
+type runLogs struct {
+  path     string
+  label    string                // Job label as it appears in the logging path
+  modified time.Time             // Last time output was written
+  size     int64
+}
+
+// retentionPolicy - How many runs of a job are kept, for how long and in how much space.  Zero is unlimited
+type retentionPolicy struct {
+  keepRuns int
+  keepDays int
+  maxBytes int64
+}
+
+// retention - The job's retention policy, with the daemon's LogKeepRuns and LogKeepDays filling in what it doesn't set
+func (j *JobConfig) retention() (retentionPolicy) {
+
+  policy := retentionPolicy{keepRuns: j.KeepRuns, keepDays: j.KeepDays}
+  if policy.keepRuns == 0 {
+    policy.keepRuns = conf.Attr.LogKeepRuns
+  }
+  if policy.keepDays == 0 {
+    policy.keepDays = conf.Attr.LogKeepDays
+  }
+  if j.MaxLogBytes != "" {
+    policy.maxBytes, _ = parseByteSize(j.MaxLogBytes)
+  }
+
+  return policy
+}
+
+// HousekeepLogs - Compress the logs of finished runs and remove those the retention policies no longer keep.  Runs
+//  whose tokens are active are left alone.  Logs of jobs no longer in the schedule fall under the daemon defaults
+func HousekeepLogs(loggingPath string, jobs []JobConfig, activeTokens map[string]bool, now time.Time) (error) {
+
+  runs, activeBytes, err := findRunLogs(loggingPath, activeTokens)
+  if err != nil {
+    return err
+  }
+
+  if conf.Attr.LogCompress == true {
+    for runIndex, _ := range runs {
+      if err := runs[runIndex].compress(); err != nil {
+        logrus.Warn("Could not compress the logs in " + runs[runIndex].path + ": " + err.Error())
+      }
+    }
+  }
+
+  policies := make(map[string]retentionPolicy)
+  for jobIndex, _ := range jobs {
+    policies[strings.Replace(jobs[jobIndex].Label, " ", "_", -1)] = jobs[jobIndex].retention()
+  }
+  defaultPolicy := (&JobConfig{}).retention()
+
+  // Newest first, so a job's runs are kept until one of its limits is reached
+  sort.Slice(runs, func(i, j int) bool { return runs[i].modified.After(runs[j].modified) })
+  removed := make(map[string]bool)
+  keptRuns := make(map[string]int)
+  keptBytes := make(map[string]int64)
+  for runIndex, _ := range runs {
+    run := runs[runIndex]
+    policy, exists := policies[run.label]
+    if exists == false {
+      policy = defaultPolicy
+    }
+    if (policy.keepRuns > 0 && keptRuns[run.label] >= policy.keepRuns) ||
+      (policy.keepDays > 0 && now.Sub(run.modified) > time.Duration(policy.keepDays) * 24 * time.Hour) ||
+      (policy.maxBytes > 0 && keptBytes[run.label] + run.size > policy.maxBytes) {
+      removed[run.path] = true
+      continue
+    }
+    keptRuns[run.label]++
+    keptBytes[run.label] += run.size
+  }
+
+  // Then hold all logs to the daemon's limit, oldest first
+  if conf.Attr.LogMaxBytes != "" {
+    maxBytes, err := parseByteSize(conf.Attr.LogMaxBytes)
+    if err != nil {
+      logrus.Error("Ignoring LogMaxBytes: " + err.Error())
+    } else {
+      totalBytes := activeBytes
+      for _, run := range runs {
+        if removed[run.path] == false {
+          totalBytes += run.size
+        }
+      }
+      for runIndex := len(runs) - 1; runIndex >= 0 && totalBytes > maxBytes; runIndex-- {
+        if removed[runs[runIndex].path] == false {
+          removed[runs[runIndex].path] = true
+          totalBytes -= runs[runIndex].size
+        }
+      }
+    }
+  }
+
+  for _, run := range runs {
+    if removed[run.path] == false {
+      continue
+    }
+    logrus.Debug("Removing the logs in " + run.path)
+    if err := os.RemoveAll(run.path); err != nil {
+      logrus.Warn("Could not remove the logs in " + run.path + ": " + err.Error())
+    }
+  }
+  if len(removed) > 0 {
+    logrus.Info("Housekeeping removed the logs of " + strconv.Itoa(len(removed)) + " runs")
+  }
+  removeEmptyLogDirs(loggingPath)
+
+  return nil
+}
+
+// findRunLogs - Every finished run's log directory under loggingPath/<date>/<label>/<token>.  Also returns the space
+//  taken by runs that are still active
+func findRunLogs(loggingPath string, activeTokens map[string]bool) ([]runLogs, int64, error) {
+
+  var runs []runLogs
+  var activeBytes int64
+  dateDirs, err := ioutil.ReadDir(loggingPath)
+  if err != nil {
+    if os.IsNotExist(err) {
+      return runs, 0, nil
+    }
+    return nil, 0, err
+  }
+  for _, dateDir := range dateDirs {
+    if dateDir.IsDir() == false {
+      continue
+    }
+    datePath := filepath.Join(loggingPath, dateDir.Name())
+    labelDirs, _ := ioutil.ReadDir(datePath)
+    for _, labelDir := range labelDirs {
+      if labelDir.IsDir() == false {
+        continue
+      }
+      labelPath := filepath.Join(datePath, labelDir.Name())
+      runDirs, _ := ioutil.ReadDir(labelPath)
+      for _, runDir := range runDirs {
+        if runDir.IsDir() == false {
+          continue
+        }
+        // Age runs by their output rather than the directory, whose time changes as the logs are compressed
+        run := runLogs{path: filepath.Join(labelPath, runDir.Name()), label: labelDir.Name()}
+        logFiles, _ := ioutil.ReadDir(run.path)
+        for _, logFile := range logFiles {
+          run.size += logFile.Size()
+          if logFile.ModTime().After(run.modified) {
+            run.modified = logFile.ModTime()
+          }
+        }
+        if run.modified.IsZero() {
+          run.modified = runDir.ModTime()
+        }
+
+        // Retries log next to the first attempt as <token>-<attempt>
+        runToken := strings.SplitN(runDir.Name(), "-", 2)[0]
+        if activeTokens[runToken] == true {
+          activeBytes += run.size
+          continue
+        }
+        runs = append(runs, run)
+      }
+    }
+  }
+
+  return runs, activeBytes, nil
+}
+
+// compress - Gzip each output file of the run that isn't already, keeping its modification time
+func (run *runLogs) compress() (error) {
+
+  for _, fileName := range logFileNames {
+    logPath := filepath.Join(run.path, fileName)
+    info, err := os.Stat(logPath)
+    if os.IsNotExist(err) {
+      continue
+    } else if err != nil {
+      return err
+    }
+    if err := compressFile(logPath); err != nil {
+      return err
+    }
+    if err := os.Chtimes(logPath + ".gz", info.ModTime(), info.ModTime()); err != nil {
+      return err
+    }
+    compressedInfo, err := os.Stat(logPath + ".gz")
+    if err != nil {
+      return err
+    }
+    run.size += compressedInfo.Size() - info.Size()
+  }
+
+  return nil
+}
+
+// compressFile - Replace a file with a gzipped copy named <file>.gz.  The copy is only put in place once complete
+func compressFile(filePath string) (error) {
+
+  original, err := os.Open(filePath)
+  if err != nil {
+    return err
+  }
+  defer original.Close()
+
+  tempPath := filePath + ".gz.tmp"
+  compressedFile, err := os.Create(tempPath)
+  if err != nil {
+    return err
+  }
+  writer := gzip.NewWriter(compressedFile)
+  _, err = io.Copy(writer, original)
+  if err == nil {
+    err = writer.Close()
+  }
+  if closeErr := compressedFile.Close(); err == nil {
+    err = closeErr
+  }
+  if err == nil {
+    err = os.Rename(tempPath, filePath + ".gz")
+  }
+  if err != nil {
+    os.Remove(tempPath)
+    return err
+  }
+
+  return os.Remove(filePath)
+}
+
+// removeEmptyLogDirs - Clear out label and date directories left empty once their runs are removed
+func removeEmptyLogDirs(loggingPath string) {
+
+  dateDirs, _ := ioutil.ReadDir(loggingPath)
+  for _, dateDir := range dateDirs {
+    if dateDir.IsDir() == false {
+      continue
+    }
+    datePath := filepath.Join(loggingPath, dateDir.Name())
+    labelDirs, _ := ioutil.ReadDir(datePath)
+    for _, labelDir := range labelDirs {
+      if labelDir.IsDir() {
+        // Remove only succeeds on empty directories
+        os.Remove(filepath.Join(datePath, labelDir.Name()))
+      }
+    }
+    os.Remove(datePath)
+  }
+}
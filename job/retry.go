@@ -0,0 +1,145 @@
+package job
+
+import (
+  "errors"
+  "math/rand"
+  "strconv"
+  "time"
+)
+
+const (
+  BACKOFFFIXED = "fixed"
+  BACKOFFEXPONENTIAL = "exponential"
+)
+
+// defaultRetryDelay - Wait before a retry when the backoff has no Delay
+const defaultRetryDelay = 30 * time.Second
+
+// defaultRetryMaxDelay - Longest an exponential backoff waits when it has no MaxDelay
+const defaultRetryMaxDelay = time.Hour
+
+// RetryBackoff - How long to wait between attempts of a failed run
+type RetryBackoff struct {
+  Strategy string                // fixed (default) waits Delay every time, exponential doubles it after each attempt
+  Delay    string                // Duration (ex '30s') before the first retry.  Defaults to 30s
+  MaxDelay string                // Longest an exponential backoff may wait.  Defaults to 1h
+  Jitter   bool                  // Wait a random time between half and all of each delay so retries don't line up
+}
+
+// checkRetries - Sanity checks on the retry settings
+func (j *JobConfig) checkRetries() (error) {
+
+  if j.Retries < 0 {
+    return errors.New("Config error: Job [" + j.Label + "] retries cannot be negative: " + strconv.Itoa(j.Retries))
+  }
+  backoff := j.RetryBackoff
+  if backoff.Strategy != "" && backoff.Strategy != BACKOFFFIXED && backoff.Strategy != BACKOFFEXPONENTIAL {
+    return errors.New("Config error: Job [" + j.Label + "] retry strategy must be either 'fixed' or 'exponential'")
+  }
+  if backoff.Delay != "" {
+    if delay, err := time.ParseDuration(backoff.Delay); err != nil || delay < 0 {
+      return errors.New("Config error: Job [" + j.Label + "] has an unparsable retry delay: " + backoff.Delay)
+    }
+  }
+  if backoff.MaxDelay != "" {
+    if maxDelay, err := time.ParseDuration(backoff.MaxDelay); err != nil || maxDelay < 0 {
+      return errors.New("Config error: Job [" + j.Label + "] has an unparsable retry maxDelay: " + backoff.MaxDelay)
+    }
+  }
+
+  return nil
+}
+
+// ShouldRetry - Whether the run gets another attempt.  Runs that failed or timed out are retried until the job's
+//  Retries are used up.  With RetryOnExitCodes only those exit codes are retried.  Stopped runs never are
+func (j *JobConfig) ShouldRetry(result JobResult) (bool) {
+
+  // Runs started outside of the daemon don't count attempts, so they are on their first
+  attempt := result.Attempt
+  if attempt == 0 {
+    attempt = 1
+  }
+  if attempt > j.Retries {
+    return false
+  }
+  if len(j.RetryOnExitCodes) > 0 {
+    for _, retryCode := range j.RetryOnExitCodes {
+      if result.ExitCode == retryCode && result.Status != STATUSKILLED {
+        return true
+      }
+    }
+    return false
+  }
+
+  return result.Status == STATUSFAILED || result.Status == STATUSTIMEDOUT
+}
+
+// RetryDelay - How long to wait after the passed attempt failed before starting the next one
+func (j *JobConfig) RetryDelay(attempt int) (time.Duration) {
+
+  backoff := j.RetryBackoff
+  delay := defaultRetryDelay
+  if backoff.Delay != "" {
+    delay, _ = time.ParseDuration(backoff.Delay)
+  }
+
+  if backoff.Strategy == BACKOFFEXPONENTIAL {
+    maxDelay := defaultRetryMaxDelay
+    if backoff.MaxDelay != "" {
+      maxDelay, _ = time.ParseDuration(backoff.MaxDelay)
+    }
+    if maxDelay <= 0 {
+      maxDelay = defaultRetryMaxDelay
+    }
+
+    // Stop doubling at the cap, well before the delay could overflow
+    for doubling := 1; doubling < attempt && delay < maxDelay; doubling++ {
+      delay *= 2
+    }
+    if delay > maxDelay {
+      delay = maxDelay
+    }
+  }
+
+  if backoff.Jitter && delay > 1 {
+    delay = delay / 2 + time.Duration(rand.Int63n(int64(delay / 2)))
+  }
+
+  return delay
+}
+
+// WaitForRetry - Sit out the delay before the next attempt while still answering the API.  A stop request cancels the
+//  remaining attempts and returns false
+func (r *RunningJob) WaitForRetry(delay time.Duration) (bool) {
+
+  retryTimer := time.NewTimer(delay)
+  defer retryTimer.Stop()
+  for {
+    select {
+    case <-retryTimer.C:
+      return true
+    case comm := <-r.Channel:
+      if comm.Signal == "stop process" {
+        r.Channel <- ChanComm{Signal: "success"}
+        return false
+      }
+      r.Channel <- ChanComm{Error: errors.New("unknown command")}
+    }
+  }
+}
+
+// PrepareNextAttempt - Reset the outcome of the last attempt and move on to the next one under the same token
+func (r *RunningJob) PrepareNextAttempt() {
+
+  r.Attempt++
+  r.RetryAt = time.Time{}
+  r.StartTime = time.Now()
+  r.EndTime = time.Time{}
+  r.ExitCode = -1
+  r.Signal = ""
+  r.TimedOut = false
+  r.PID = 0
+  r.CgroupPath = ""
+  r.Usage = RunUsage{}
+  r.LogDir = r.DetermineLoggingDir()
+}
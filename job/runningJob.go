@@ -5,17 +5,34 @@ import (
   "os/exec"
   "bufio"
   "io"
+  "io/ioutil"
   "os"
   "strings"
+  "strconv"
   "crypto/rand"
   "sync"
   "time"
-  "net/http"
   "errors"
+  "syscall"
   "github.com/Sirupsen/logrus"
   "github.com/brysearl/omicrond/conf"
 )
 
+const (
+  TRIGGERSCHEDULE = "schedule"
+  TRIGGERDEPENDENCY = "dependency"
+  TRIGGERMANUAL = "manual"
+  TRIGGERREBOOT = "reboot"
+  TRIGGERCATCHUP = "catch up"
+)
+
+// outputLineLimit - Longest piece of output passed on as one line.  The scanner's buffer stops growing at this size
+const outputLineLimit = bufio.MaxScanTokenSize
+
+// outputDrainTimeout - How long output is still read once the command has exited.  Background processes it left
+//  behind can hold its output open indefinitely
+var outputDrainTimeout = 5 * time.Second
+
 type RunningJobTracker struct {
   Sync *sync.RWMutex
   Jobs map[string]RunningJob
@@ -29,6 +46,24 @@ type RunningJob struct {
   StdOut    io.ReadCloser
   StdErr    io.ReadCloser
   StartTime time.Time
+  EndTime   time.Time
+  ExitCode  int
+  Signal    string
+  TimedOut  bool              // The run outlived its Timeout and was terminated
+  Attempt   int               // Attempt of the run, starting at 1.  Retries keep the same Token
+  PID       int               // Process id of the command once it has started
+  CgroupPath string           // cgroup the command runs in.  Empty when it runs outside of one
+  Usage     RunUsage          // Resources used by the command once it has finished
+  RetryAt   time.Time         // When the next attempt starts while waiting out a retry backoff
+  LogDir    string
+  Trigger   string            // What started the run: schedule, dependency, manual, reboot or catch up
+  MissedAt  time.Time         // Occurrence a catch up run makes up for.  Zero for every other trigger
+  ExtraArgs []string          // One-off arguments appended to the command
+  ExtraEnv  []string          // One-off KEY=value environment overrides
+  Queued    bool              // Waiting for room under its concurrency limits.  Queued runs haven't started yet
+  QueuedAt  time.Time         // When the run was queued.  Queued runs start in this order
+  Admitted  chan bool         // Closed by the tracker when a queued run may start
+  Done      chan bool         // Closed once the run's last attempt has finished and it has left the tracker
 }
 
 type RunningJobTrackerAPI struct {
@@ -37,18 +72,33 @@ type RunningJobTrackerAPI struct {
 
 type RunningJobAPI struct {
   Token       string
+  Trigger     string
+  MissedAt    time.Time         // Occurrence a catch up run makes up for
+  Queued      bool              // Waiting for room under its concurrency limits.  Queued runs have no StartTime
+  QueuedAt    time.Time
   StartTime   time.Time
   ElapsedTime time.Duration
+  Deadline    time.Time         // When the run will be sent SIGTERM.  Zero without a Timeout
+  Attempt     int               // Attempt of the run, out of Config.Retries + 1
+  RetryAt     time.Time         // When the next attempt starts.  Zero unless waiting to retry
   PID         int
-  MemUse      int
+  MemUse      int               // Bytes of memory charged to the run's cgroup, or the tree's RSS without one
+  Children    int               // Processes spawned by the command that are still running
+  RSS         int64             // Resident memory of the command and its children in bytes
+  UserTime    time.Duration     // User CPU time of the command and its children
+  SystemTime  time.Duration     // System CPU time of the command and its children
+  ReadBytes   int64             // Bytes the command and its children read from storage
+  WriteBytes  int64             // Bytes the command and its children wrote to storage
+  CPUTime     time.Duration     // CPU time used by the run's cgroup
+  Pids        int               // Processes and threads in the run's cgroup
 
   Config      JobConfigAPI
 }
 
 type ChanComm struct {
-  Signal string
-  Error  error
-  Writer http.ResponseWriter
+  Signal     string
+  StopSignal string            // Signal name (ex 'TERM') sent by a "stop process" request.  Empty uses the job's StopSignal
+  Error      error
 }
 
 // MakeAPIFormat - Convert internal object into external data
@@ -85,9 +135,38 @@ func (j *RunningJob) MakeAPIFormat(jobToken string) (RunningJobAPI, error) {
 
   apiRunningJob := RunningJobAPI{
     Token:  jobToken,
-    StartTime: j.StartTime,
-    ElapsedTime: time.Now().Sub(j.StartTime),
+    Trigger: j.Trigger,
+    MissedAt: j.MissedAt,
+    Attempt: j.Attempt,
+    RetryAt: j.RetryAt,
+    Queued: j.Queued,
+    QueuedAt: j.QueuedAt,
+    PID: j.PID,
     Config:  apiConf }
+  if j.Queued == true {
+    return apiRunningJob, err
+  }
+  apiRunningJob.StartTime = j.StartTime
+  apiRunningJob.ElapsedTime = time.Now().Sub(j.StartTime)
+  if timeout, _ := j.Config.Timeouts(); timeout > 0 {
+    apiRunningJob.Deadline = j.StartTime.Add(timeout)
+  }
+  if j.PID != 0 {
+    stats := readProcessTree(procRoot, j.PID)
+    apiRunningJob.Children = stats.Children
+    apiRunningJob.RSS = stats.RSS
+    apiRunningJob.MemUse = int(stats.RSS)
+    apiRunningJob.UserTime = stats.UserTime
+    apiRunningJob.SystemTime = stats.SystemTime
+    apiRunningJob.ReadBytes = stats.ReadBytes
+    apiRunningJob.WriteBytes = stats.WriteBytes
+  }
+  if j.CgroupPath != "" {
+    usage := readCgroupUsage(j.CgroupPath)
+    apiRunningJob.MemUse = int(usage.Memory)
+    apiRunningJob.CPUTime = usage.CPUTime
+    apiRunningJob.Pids = usage.Pids
+  }
 
   return apiRunningJob, err
 }
@@ -97,97 +176,247 @@ func (r *RunningJob) Run(running *RunningJobTracker) {
 
   var err error
 
+  // Assume failure until the command is seen to complete.  However the attempt ends, it gets an end time
+  r.ExitCode = -1
+  defer func() {
+    if r.EndTime.IsZero() {
+      r.EndTime = time.Now()
+    }
+  }()
+
+  // Fix the log directory for the whole run so it doesn't move at midnight
+  if r.LogDir == "" {
+    r.LogDir = r.DetermineLoggingDir()
+  }
+
   // Make the command executable
   running.Sync.Lock()
-  r.Exec = r.buildCommand()
+  r.Exec, err = r.buildCommand()
   running.Sync.Unlock()
   if err != nil {
-    logrus.Error(err)
+    r.Logger().Error(err)
     return
   }
 
-  // Create handles for both stdin and stdout
-  running.Sync.Lock()
-  r.StdOut, err = r.Exec.StdoutPipe()
-  running.Sync.Unlock()
+  // Place the command in its own cgroup under the job's resource limits
+  cgroupDir, err := r.joinCgroup(r.Exec)
   if err != nil {
-    logrus.Error(err)
+    r.Logger().Error(err)
     return
   }
-  running.Sync.Lock()
-  r.StdErr, err = r.Exec.StderrPipe()
-  running.Sync.Unlock()
+  defer r.removeCgroup()
+
+  // Create pipes for both stdout and stderr.  The command is handed the write ends, which are closed here once it has
+  //  started
+  stdOutReader, stdOutWriter, err := os.Pipe()
   if err != nil {
-    logrus.Error(err)
+    r.Logger().Error(err)
     return
   }
+  defer stdOutReader.Close()
+  stdErrReader, stdErrWriter, err := os.Pipe()
+  if err != nil {
+    stdOutWriter.Close()
+    r.Logger().Error(err)
+    return
+  }
+  defer stdErrReader.Close()
+  running.Sync.Lock()
+  r.StdOut, r.StdErr = stdOutReader, stdErrReader
+  r.Exec.Stdout, r.Exec.Stderr = stdOutWriter, stdErrWriter
+  running.Sync.Unlock()
 
-  // Attach scanners to the IO handles
-  stdOutScanner := bufio.NewScanner(r.StdOut)
-  stdErrScanner := bufio.NewScanner(r.StdErr)
-
-  // Spawn goroutines to effectively tail the IO scanners
-  go func(r *RunningJob) {
-
-    // Setup logfile for STDOUT
-    logPath := r.DetermineLoggingDir()
-    if err := os.MkdirAll(logPath, 0755); err != nil {
-      logrus.Error(err)
-    }
-    logFile, err := os.Create(logPath + "/stdout.txt")
+  // Forward output lines to syslog and the journal.  Targets that can't be reached don't stop the job
+  var forwarder *logForwarder
+  if targets := r.Config.forwardTargets(); len(targets) > 0 {
+    forwarder, err = openLogForwarder(targets)
     if err != nil {
-      logrus.Error(err)
+      r.Logger().Warn(err)
     }
+    defer forwarder.Close()
+  }
 
-    // Scan each line as they become available
-    for stdOutScanner.Scan() {
-      logrus.Debug("STDOUT | " + stdOutScanner.Text())
-      logFile.WriteString(stdOutScanner.Text() + "\n")
-    }
-    logFile.Close()
+  // Spawn goroutines to tail both streams.  Each reads until every process holding the pipe has exited, or the pipe
+  //  is closed once the command is done, dropping output past the job's limit, so the command never blocks on a full
+  //  pipe
+  outputLimit := r.Config.OutputLimit()
+  var outputWritten sync.WaitGroup
+  outputWritten.Add(2)
+  go func(r *RunningJob) {
+    defer outputWritten.Done()
+    r.captureOutput("stdout", r.StdOut, r.StdOutPath(), outputLimit, forwarder)
   }(r)
   go func(r *RunningJob) {
-
-    // Setup logfile for STDERR
-    logPath := r.DetermineLoggingDir()
-    if err := os.MkdirAll(logPath, 0755); err != nil {
-      logrus.Error(err)
-    }
-    logFile, err := os.Create(logPath + "/stderr.txt")
-    if err != nil {
-      logrus.Error(err)
-    }
-
-    // Scan each line as they become available
-    for stdErrScanner.Scan() {
-      logrus.Debug("STDERR | " + stdErrScanner.Text())
-      logFile.WriteString(stdErrScanner.Text() + "\n")
-    }
-    logFile.Close()
+    defer outputWritten.Done()
+    r.captureOutput("stderr", r.StdErr, r.StdErrPath(), outputLimit, forwarder)
   }(r)
 
-  // Open up channel to extend to API
-  go r.listenOnChannel(stdOutScanner)
-
   // Start the command
-  logrus.Info("Running [" + r.Config.Label + "]: " + strings.Join(r.Exec.Args, " "))
-  err = r.Exec.Start()
+  r.Logger().Info("Running [" + r.Config.Label + "]: " + strings.Join(r.Exec.Args, " "))
+  err = r.startInCgroup(r.Exec, cgroupDir)
+  stdOutWriter.Close()
+  stdErrWriter.Close()
   if err != nil {
-    logrus.Error(err)
+    r.Logger().Error(err)
     return
   }
 
+  // Let the API see the process and its cgroup
+  r.PID = r.Exec.Process.Pid
+  running.Update(*r)
+
+  // Open up channel to extend to API once there is a process to stop
+  go r.listenOnChannel()
+
+  // Terminate the command if it runs past its timeout
+  done := make(chan bool)
+  timedOut := make(chan bool, 1)
+  go r.enforceTimeout(r.Exec, done, timedOut)
+
   // Wait for the command to complete
-  logrus.Debug("Waiting for command to complete")
+  r.Logger().Debug("Waiting for command to complete")
   r.Exec.Wait()
+  r.EndTime = time.Now()
+  close(done)
+  r.TimedOut = <-timedOut
+  r.ExitCode, r.Signal = determineExitStatus(r.Exec)
+  r.Usage = runUsage(r.Exec.ProcessState)
+
+  // Read what is left of the output.  Processes the command left running in the background may still hold the pipes
+  //  open, so stop reading after a while rather than keep the run going
+  outputDrained := make(chan bool)
+  go func() {
+    outputWritten.Wait()
+    close(outputDrained)
+  }()
+  select {
+  case <-outputDrained:
+  case <-time.After(outputDrainTimeout):
+    r.Logger().Warn("[" + r.Config.Label + "] left processes holding its output open.  Their output is no longer captured")
+    stdOutReader.Close()
+    stdErrReader.Close()
+    <-outputDrained
+  }
   r.Channel <- ChanComm{Signal:"end"}
-  logrus.Debug("Command completed")
+  r.Logger().Debug("Command completed with return code " + strconv.Itoa(r.ExitCode))
 
   return
 }
 
+// Result - Summarize the completed run for dependency resolution and the run history
+func (r *RunningJob) Result() JobResult {
+
+  result := JobResult{
+    Token: r.Token,
+    Label: r.Config.Label,
+    Trigger: r.Trigger,
+    MissedAt: r.MissedAt,
+    StartTime: r.StartTime,
+    EndTime: r.EndTime,
+    ExitCode: r.ExitCode,
+    Signal: r.Signal,
+    TimedOut: r.TimedOut,
+    Attempt: r.Attempt,
+    Usage: r.Usage}
+  result.Status = result.DetermineStatus()
+
+  // Only point at logs that were actually written
+  if r.LogDir != "" {
+    result.StdOutPath = r.StdOutPath()
+    result.StdErrPath = r.StdErrPath()
+  }
+
+  return result
+}
+
+// determineExitStatus - Read the return code of a completed command and the name of the signal that killed it.
+//  Commands killed by a signal return -1
+func determineExitStatus(cmd *exec.Cmd) (int, string) {
+
+  if cmd.ProcessState == nil {
+    return -1, ""
+  }
+  if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+    if status.Signaled() {
+      return -1, status.Signal().String()
+    }
+    return status.ExitStatus(), ""
+  }
+  if cmd.ProcessState.Success() {
+    return 0, ""
+  }
+
+  return -1, ""
+}
+
+// Logger - Log entry carrying the run's label, token and group so log pipelines can tie each line to the run
+func (r *RunningJob) Logger() (*logrus.Entry) {
+
+  if r.Token == "" {
+    return r.Config.Logger()
+  }
+
+  return r.Config.Logger().WithField("token", r.Token)
+}
+
+// captureOutput - Write each line of one of the run's output streams to its log file, the daemon's log and any
+//  forwarding targets.  Whatever can't be scanned is still read to the end so the command isn't left blocked
+func (r *RunningJob) captureOutput(stream string, output io.Reader, logPath string, outputLimit int64, forwarder *logForwarder) {
+
+  // Setup the stream's logfile
+  if err := os.MkdirAll(r.LogDir, 0755); err != nil {
+    r.Logger().Error(err)
+  }
+  logFile, err := createOutputLog(logPath, outputLimit)
+  if err != nil {
+    r.Logger().Error(err)
+  }
+
+  // Scan each line as they become available
+  outputLogger := r.Logger().WithField("stream", stream)
+  outputScanner := bufio.NewScanner(output)
+  outputScanner.Split(scanOutputLines)
+  for outputScanner.Scan() {
+    logOutputLine(outputLogger, strings.ToUpper(stream), outputScanner.Text())
+    forwarder.send(r.outputEvent(stream, outputScanner.Text()))
+    if logFile != nil {
+      logFile.WriteLine(outputScanner.Text())
+    }
+  }
+  if err := outputScanner.Err(); err != nil && errors.Is(err, os.ErrClosed) == false {
+    r.Logger().Warn("Could not read the " + stream + " of [" + r.Config.Label + "]: " + err.Error())
+    io.Copy(ioutil.Discard, output)
+  }
+  if logFile != nil {
+    logFile.Close()
+  }
+}
+
+// scanOutputLines - bufio.ScanLines, except lines longer than outputLineLimit, including binary output without
+//  newlines, are cut into pieces rather than stopping the scanner with ErrTooLong
+func scanOutputLines(data []byte, atEOF bool) (int, []byte, error) {
+
+  advance, token, err := bufio.ScanLines(data, atEOF)
+  if advance == 0 && token == nil && err == nil && len(data) >= outputLineLimit {
+    return outputLineLimit, data[:outputLineLimit], nil
+  }
+
+  return advance, token, err
+}
+
+// logOutputLine - Pass a line of the job's output on to the daemon's log.  With LogJobOutput each line is an info
+//  event of its own, otherwise output only shows when debugging
+func logOutputLine(outputLogger *logrus.Entry, prefix string, line string) {
+
+  if conf.Attr.LogJobOutput == true {
+    outputLogger.Info(line)
+  } else {
+    outputLogger.Debug(prefix + " | " + line)
+  }
+}
+
 // listenOnChannel - open up channel communication for API commands
-func (r *RunningJob) listenOnChannel(stdOutScanner *bufio.Scanner) {
+func (r *RunningJob) listenOnChannel() {
   stop := false
   for stop == false {
     comm := <-r.Channel
@@ -195,46 +424,46 @@ func (r *RunningJob) listenOnChannel(stdOutScanner *bufio.Scanner) {
     case "end":
       stop = true
     case "stop process":
-      err := r.Exec.Process.Kill()
+      err := r.stopProcessGroup(comm.StopSignal)
       if err != nil {
-        r.Channel <- ChanComm{Error: errors.New("failed")}
+        r.Channel <- ChanComm{Error: err}
+        continue
       }
       r.Channel <- ChanComm{Signal: "success"}
-    case "tail process":
-
-      func(comm ChanComm, stdOutScanner *bufio.Scanner) {
-        for stdOutScanner.Scan() {
-          logrus.Debug("should see " + stdOutScanner.Text())
-          //fmt.Fprintf(comm.Writer, stdOutScanner.Text())
-          //bufrw.WriteString(stdOutScanner.Text())
-          comm.Writer.Write(stdOutScanner.Bytes())
-          f, ok := comm.Writer.(http.Flusher)
-          if ok {
-            logrus.Info("Flushing")
-            f.Flush()
-          }
-          //bufrw.Flush()
-        }
-
-
-      }(comm, stdOutScanner)
-      logrus.Info("HERE")
-      r.Channel <- ChanComm{Signal: "success"}
-    //}(conn, stdOutScanner, bufrw)
     default:
       r.Channel <- ChanComm{Error: errors.New("unknown command")}
     }
   }
-  logrus.Debug("Stopped command channel")
+  r.Logger().Debug("Stopped command channel")
 }
 
-// buildCommand - Convert string to executablte exec.Cmd type
-func (r *RunningJob) buildCommand() *exec.Cmd {
+// buildCommand - Convert string to executablte exec.Cmd type.  With a Shell the command is handed to it whole,
+//  otherwise it is split into words with SplitCommandWords
+func (r *RunningJob) buildCommand() (*exec.Cmd, error) {
 
-  // Split on spaces
-  components := strings.Split(string(r.Config.Command), " ")
+  var components []string
+  var err error
+  if r.Config.Shell != "" {
+
+    // One-off arguments are quoted onto the end of the command line so the shell passes them through untouched
+    components, err = SplitCommandWords(r.Config.Shell)
+    if err != nil {
+      return nil, err
+    }
+    commandStr := r.Config.Command
+    for _, extraArg := range r.ExtraArgs {
+      commandStr += " " + shellQuote(extraArg)
+    }
+    components = append(components, commandStr)
+  } else {
+    components, err = SplitCommandWords(r.Config.Command)
+    if err != nil {
+      return nil, err
+    }
+    components = append(components, r.ExtraArgs...)
+  }
   if len(components) == 0 {
-    logrus.Error("Missing exec command in job configuration")
+    return nil, errors.New("Missing exec command in job configuration")
   }
 
   // Shift off the executable from the arguments
@@ -242,13 +471,46 @@ func (r *RunningJob) buildCommand() *exec.Cmd {
 
   // Create the exec.Cmd object and attach to JobConfig
   cmdPtr := exec.Command(executable, components...)
-  return cmdPtr
+
+  // Start the command in its own session and process group so it can be stopped along with everything it spawns.
+  //  Switch to the job's user and group
+  credential, loginEnv, err := r.Config.credential()
+  if err != nil {
+    return nil, err
+  }
+  cmdPtr.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Credential: credential}
+
+  // Layer the user's login variables, the job's environment and any one-off overrides
+  cmdPtr.Env, err = r.Config.environment(loginEnv, r.ExtraEnv)
+  if err != nil {
+    return nil, err
+  }
+  cmdPtr.Dir = r.Config.WorkingDir
+
+  return cmdPtr, nil
 }
 
-// DetermineLoggingPath - Get the filepath to write new logs to
+// DetermineLoggingPath - Get the filepath to write new logs to.  Retries get their own directory next to the first attempt
 func (r *RunningJob) DetermineLoggingDir() string {
 
-  return conf.Attr.LoggingPath + "/" + time.Now().Format("2006-01-02") + "/" + strings.Replace(r.Config.Label, " ", "_", -1) + "/" + r.Token
+  logDir := conf.Attr.LoggingPath + "/" + time.Now().Format("2006-01-02") + "/" + strings.Replace(r.Config.Label, " ", "_", -1) + "/" + r.Token
+  if r.Attempt > 1 {
+    logDir += "-" + strconv.Itoa(r.Attempt)
+  }
+
+  return logDir
+}
+
+// StdOutPath - Get the file the run's STDOUT is written to
+func (r *RunningJob) StdOutPath() string {
+
+  return r.LogDir + "/stdout.txt"
+}
+
+// StdErrPath - Get the file the run's STDERR is written to
+func (r *RunningJob) StdErrPath() string {
+
+  return r.LogDir + "/stderr.txt"
 }
 
 func CreateRunToken() string {
@@ -256,3 +518,26 @@ func CreateRunToken() string {
   rand.Read(b)
   return fmt.Sprintf("%x", b)
 }
+
+// Update - Replace the tracker's copy of a run with the latest state of the run.  Runs no longer tracked are left out
+func (t *RunningJobTracker) Update(runningJob RunningJob) {
+
+  t.Sync.Lock()
+  if _, tracked := t.Jobs[runningJob.Token]; tracked {
+    t.Jobs[runningJob.Token] = runningJob
+  }
+  t.Sync.Unlock()
+}
+
+// ActiveTokens - Tokens of every tracked run, including runs waiting to retry
+func (t *RunningJobTracker) ActiveTokens() (map[string]bool) {
+
+  t.Sync.RLock()
+  defer t.Sync.RUnlock()
+  activeTokens := make(map[string]bool)
+  for runToken, _ := range t.Jobs {
+    activeTokens[runToken] = true
+  }
+
+  return activeTokens
+}
@@ -0,0 +1,58 @@
+package job
+
+import (
+  "errors"
+  "strings"
+  "syscall"
+)
+
+// stopSignals - Signals a stop request may send to a job
+var stopSignals = map[string]syscall.Signal{
+  "TERM": syscall.SIGTERM,
+  "INT":  syscall.SIGINT,
+  "HUP":  syscall.SIGHUP,
+  "KILL": syscall.SIGKILL,
+}
+
+// ParseStopSignal - Translate a signal name (ex 'TERM', 'sigterm') into a signal a stop request may send
+func ParseStopSignal(name string) (syscall.Signal, error) {
+
+  stopSignal, exists := stopSignals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+  if exists == false {
+    return 0, errors.New("Stop signal must be one of TERM, INT, HUP or KILL: " + name)
+  }
+
+  return stopSignal, nil
+}
+
+// checkStopSignal - Make sure the job's StopSignal is one a stop request may send
+func (j *JobConfig) checkStopSignal() (error) {
+
+  if j.StopSignal == "" {
+    return nil
+  }
+  if _, err := ParseStopSignal(j.StopSignal); err != nil {
+    return errors.New("Config error: Job [" + j.Label + "] " + err.Error())
+  }
+
+  return nil
+}
+
+// stopProcessGroup - Send a signal to the job's whole process group so nothing it spawned is left behind.  The
+//  signal is signalName if set, otherwise the job's StopSignal, otherwise SIGKILL
+func (r *RunningJob) stopProcessGroup(signalName string) (error) {
+
+  if signalName == "" {
+    signalName = r.Config.StopSignal
+  }
+  stopSignal := syscall.SIGKILL
+  if signalName != "" {
+    var err error
+    stopSignal, err = ParseStopSignal(signalName)
+    if err != nil {
+      return err
+    }
+  }
+
+  return syscall.Kill(-r.Exec.Process.Pid, stopSignal)
+}
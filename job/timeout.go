@@ -0,0 +1,73 @@
+package job
+
+import (
+  "errors"
+  "os/exec"
+  "syscall"
+  "time"
+)
+
+// defaultKillGracePeriod - Time a timed out job has to exit after SIGTERM when KillGracePeriod is empty
+const defaultKillGracePeriod = 10 * time.Second
+
+// checkTimeout - Make sure the timeout and grace period are positive durations
+func (j *JobConfig) checkTimeout() (error) {
+
+  if j.Timeout != "" {
+    timeout, err := time.ParseDuration(j.Timeout)
+    if err != nil || timeout <= 0 {
+      return errors.New("Config error: Job [" + j.Label + "] has an unparsable or non-positive timeout: " + j.Timeout)
+    }
+  }
+  if j.KillGracePeriod != "" {
+    gracePeriod, err := time.ParseDuration(j.KillGracePeriod)
+    if err != nil || gracePeriod < 0 {
+      return errors.New("Config error: Job [" + j.Label + "] has an unparsable or negative killGracePeriod: " + j.KillGracePeriod)
+    }
+  }
+
+  return nil
+}
+
+// Timeouts - The run time allowed before the job is sent SIGTERM, 0 for none, and the time it then has before SIGKILL
+func (j *JobConfig) Timeouts() (time.Duration, time.Duration) {
+
+  var timeout time.Duration
+  gracePeriod := defaultKillGracePeriod
+  if j.Timeout != "" {
+    timeout, _ = time.ParseDuration(j.Timeout)
+  }
+  if j.KillGracePeriod != "" {
+    gracePeriod, _ = time.ParseDuration(j.KillGracePeriod)
+  }
+
+  return timeout, gracePeriod
+}
+
+// enforceTimeout - Once the job's timeout passes, send SIGTERM to its process group and escalate to SIGKILL if it is
+//  still running after the grace period.  Closing done stops the clock.  Sends whether the job timed out on timedOut
+func (r *RunningJob) enforceTimeout(cmd *exec.Cmd, done chan bool, timedOut chan bool) {
+
+  timeout, gracePeriod := r.Config.Timeouts()
+  if timeout <= 0 {
+    timedOut <- false
+    return
+  }
+
+  select {
+  case <-done:
+    timedOut <- false
+    return
+  case <-time.After(timeout):
+  }
+  r.Logger().Warn("[" + r.Config.Label + "] timed out after " + timeout.String() + ".  Sending SIGTERM")
+  syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+
+  select {
+  case <-done:
+  case <-time.After(gracePeriod):
+    r.Logger().Warn("[" + r.Config.Label + "] still running " + gracePeriod.String() + " after SIGTERM.  Sending SIGKILL")
+    syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+  }
+  timedOut <- true
+}
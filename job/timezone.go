@@ -0,0 +1,125 @@
+package job
+
+import (
+  "errors"
+  "strings"
+  "time"
+)
+
+// DST policies decide what happens to scheduled wall clock times that a daylight saving change jumps over (the gap)
+//  or passes through twice (the repeat).  Like cron, they only apply to jobs at a fixed time of day.  Jobs with a '*'
+//  minute or hour field keep following the clock
+const (
+  DSTSKIP = "skip"   // Times in the gap are skipped.  Repeated times run on the first pass only
+  DSTONCE = "once"   // Times in the gap run once right after it.  Repeated times run on the first pass only
+  DSTTWICE = "twice" // Times in the gap run once right after it.  Repeated times run on both passes
+)
+
+// checkTimezone - Make sure the job's time zone is a known IANA name and its DST policy is understood
+func (j *JobConfig) checkTimezone() (error) {
+
+  if _, err := j.Location(); err != nil {
+    return err
+  }
+  if j.DSTPolicy != "" && j.DSTPolicy != DSTSKIP && j.DSTPolicy != DSTONCE && j.DSTPolicy != DSTTWICE {
+    return errors.New("Config error: Job [" + j.Label + "] DSTPolicy must be either 'skip', 'once' or 'twice'")
+  }
+
+  return nil
+}
+
+// Location - The time zone the job's schedule is evaluated in.  Jobs without a Timezone use the daemon's zone
+func (j *JobConfig) Location() (*time.Location, error) {
+
+  if j.Timezone == "" {
+    return time.Local, nil
+  }
+  if j.location != nil && j.location.String() == j.Timezone {
+    return j.location, nil
+  }
+  location, err := time.LoadLocation(j.Timezone)
+  if err != nil {
+    return nil, errors.New("Config error: Job [" + j.Label + "] has an unknown timezone: " + j.Timezone)
+  }
+
+  return location, nil
+}
+
+// IsDue - Whether the job should run at the passed instant.  The schedule is checked against the wall clock of the
+//  job's time zone, following its DSTPolicy through daylight saving changes
+func (j *JobConfig) IsDue(instant time.Time) (bool) {
+
+  location, err := j.Location()
+  if err != nil {
+    return false
+  }
+  localTime := instant.In(location)
+  if j.isFixedTime() == false {
+    return j.CheckIfScheduled(localTime)
+  }
+
+  if j.CheckIfScheduled(localTime) {
+    return j.DSTPolicy == DSTTWICE || isRepeatedWallClock(localTime) == false
+  }
+  if j.DSTPolicy == DSTSKIP {
+    return false
+  }
+
+  return j.scheduledInSkippedWallClock(localTime)
+}
+
+// isFixedTime - Whether the job runs at a fixed time of day rather than on a repeating interval of the clock
+func (j *JobConfig) isFixedTime() (bool) {
+
+  _, scheduleChunks, err := j.splitSchedule()
+  if err != nil {
+    return false
+  }
+
+  return strings.HasPrefix(scheduleChunks[MINUTE], "*") == false && strings.HasPrefix(scheduleChunks[HOUR], "*") == false
+}
+
+// isRepeatedWallClock - Whether the wall clock time already happened once, earlier in the day, before the clocks
+//  were turned back
+func isRepeatedWallClock(localTime time.Time) (bool) {
+
+  zoneStart, _ := localTime.ZoneBounds()
+  if zoneStart.IsZero() {
+    return false
+  }
+  _, offset := localTime.Zone()
+  _, previousOffset := zoneStart.Add(-time.Second).Zone()
+  repeated := time.Duration(previousOffset - offset) * time.Second
+
+  return repeated > 0 && localTime.Sub(zoneStart) < repeated
+}
+
+// scheduledInSkippedWallClock - Whether the clocks were just turned forward over a wall clock time the job was
+//  scheduled for.  Only true on the first check after the change
+func (j *JobConfig) scheduledInSkippedWallClock(localTime time.Time) (bool) {
+
+  zoneStart, _ := localTime.ZoneBounds()
+  if zoneStart.IsZero() || localTime.Equal(zoneStart) == false {
+    return false
+  }
+  _, offset := localTime.Zone()
+  _, previousOffset := zoneStart.Add(-time.Second).Zone()
+  skipped := time.Duration(offset - previousOffset) * time.Second
+  if skipped <= 0 {
+    return false
+  }
+
+  // Filters only look at the wall clock, so UTC can stand in for times that never existed in the job's zone
+  step := time.Minute
+  if j.HasSecondsField() {
+    step = time.Second
+  }
+  wallClock := time.Date(localTime.Year(), localTime.Month(), localTime.Day(), localTime.Hour(), localTime.Minute(), localTime.Second(), 0, time.UTC)
+  for skippedTime := wallClock.Add(-skipped); skippedTime.Before(wallClock); skippedTime = skippedTime.Add(step) {
+    if j.CheckIfScheduled(skippedTime) {
+      return true
+    }
+  }
+
+  return false
+}
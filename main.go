@@ -1,11 +1,16 @@
 package main
 
 import (
+  "errors"
   "flag"
+  "log/syslog"
   "os"
+  "strings"
+  "time"
   "github.com/Sirupsen/logrus"
   "github.com/brysearl/omicrond/conf"
   "github.com/brysearl/omicrond/daemon"
+  "github.com/brysearl/omicrond/job"
 )
 //"github.com/davecgh/go-spew/spew"
 
@@ -14,10 +19,27 @@ func init() {
 
   // Configure command line arguments
   var logLevelPtr = flag.Int("v", conf.Attr.LogLevel, "Set the debug level: 1 = Panic, 2 = Fatal, 3 = Error, 4 = Warn, 5 = Info, 6 = Debug")
+  var logFormatPtr = flag.String("log_format", conf.Attr.LogFormat, "Format of the daemon's log: text or json")
+  var logOutputPtr = flag.String("log_output", conf.Attr.LogOutput, "Where to log: stderr, syslog or a file path")
+  var logJobOutputPtr = flag.Bool("log_job_output", conf.Attr.LogJobOutput, "Log each line of job output as an info event")
+  var logForwardPtr = flag.String("log_forward", strings.Join(conf.Attr.LogForward, ","), "Also send the daemon's log to these targets: syslog, journal or both")
+  var jobLogForwardPtr = flag.String("job_log_forward", strings.Join(conf.Attr.JobLogForward, ","), "Forward job output to these targets unless a job says otherwise: syslog, journal or both")
   var jobConfigPathPtr = flag.String("config", conf.Attr.JobConfigPath, "Path to the daemon configuration file")
   var apiAddressPtr = flag.String("api_address", conf.Attr.APIAddress, "IP to run the API service")
   var apiPortPtr = flag.Int("api_port", conf.Attr.APIPort, "Port to run the API service")
   var apiTimeoutPtr = flag.Int("api_timeout", conf.Attr.APITimeout, "API service request timeout in seconds")
+  var apiSocketPtr = flag.Bool("api_socket", conf.Attr.APISocket, "Also serve the API on a unix socket")
+  var socketPathPtr = flag.String("socket_path", conf.Attr.SocketPath, "Path to the API unix socket")
+  var scheduleModePtr = flag.String("schedule_mode", conf.Attr.ScheduleMode, "Default evaluation of job schedules: legacy or cron")
+  var maxConcurrentPtr = flag.Int("max_concurrent", conf.Attr.MaxConcurrent, "Most jobs running at once across the daemon.  0 is unlimited")
+  var cgroupRootPtr = flag.String("cgroup_root", conf.Attr.CgroupRoot, "cgroup v2 slice jobs with resource limits run under.  Empty disables cgroups")
+  var logMaxOutputPtr = flag.String("log_max_output", conf.Attr.LogMaxOutput, "Most of each output stream kept per run (ex '10M').  Empty keeps everything")
+  var logKeepRunsPtr = flag.Int("log_keep_runs", conf.Attr.LogKeepRuns, "Most runs whose logs are kept per job.  0 keeps them all")
+  var logKeepDaysPtr = flag.Int("log_keep_days", conf.Attr.LogKeepDays, "Days the logs of a run are kept.  0 keeps them forever")
+  var logMaxBytesPtr = flag.String("log_max_bytes", conf.Attr.LogMaxBytes, "Most space all job logs may take up (ex '10G').  Empty is unlimited")
+  var logCompressPtr = flag.Bool("log_compress", conf.Attr.LogCompress, "Gzip the logs of finished runs")
+  var historyKeepRunsPtr = flag.Int("history_keep_runs", conf.Attr.HistoryKeepRuns, "Most runs kept per job in the run history.  0 keeps them all")
+  var historyKeepDaysPtr = flag.Int("history_keep_days", conf.Attr.HistoryKeepDays, "Days runs are kept in the run history.  0 keeps them forever")
 
   // Retrieve command line arguments
   flag.Parse()
@@ -27,6 +49,11 @@ func init() {
 
   // Set the log level of the program
   conf.Attr.LogLevel = *logLevelPtr
+  conf.Attr.LogFormat = *logFormatPtr
+  conf.Attr.LogOutput = *logOutputPtr
+  conf.Attr.LogJobOutput = *logJobOutputPtr
+  conf.Attr.LogForward = splitTargets(*logForwardPtr)
+  conf.Attr.JobLogForward = splitTargets(*jobLogForwardPtr)
 
   // Set the address of the api service
   conf.Attr.APIAddress = *apiAddressPtr
@@ -37,6 +64,30 @@ func init() {
   // Set the port of the api service
   conf.Attr.APITimeout = *apiTimeoutPtr
 
+  // Set the unix socket of the api service
+  conf.Attr.APISocket = *apiSocketPtr
+  conf.Attr.SocketPath = *socketPathPtr
+
+  // Set how job schedules are evaluated unless a job says otherwise
+  conf.Attr.ScheduleMode = *scheduleModePtr
+
+  // Set how many jobs may run at once
+  conf.Attr.MaxConcurrent = *maxConcurrentPtr
+
+  // Set where runs get their cgroups
+  conf.Attr.CgroupRoot = *cgroupRootPtr
+
+  // Set how much job output is kept
+  conf.Attr.LogMaxOutput = *logMaxOutputPtr
+  conf.Attr.LogKeepRuns = *logKeepRunsPtr
+  conf.Attr.LogKeepDays = *logKeepDaysPtr
+  conf.Attr.LogMaxBytes = *logMaxBytesPtr
+  conf.Attr.LogCompress = *logCompressPtr
+
+  // Set how much run history is kept
+  conf.Attr.HistoryKeepRuns = *historyKeepRunsPtr
+  conf.Attr.HistoryKeepDays = *historyKeepDaysPtr
+
   // Create directories if they don't exist
   if err := os.MkdirAll(conf.Attr.BaseDir,0755); err != nil {
     logrus.Fatal(err)
@@ -62,11 +113,72 @@ func init() {
     logrus.SetLevel(logrus.InfoLevel)
   }
 
-  // Output with absolute time
-  customFormatter := new(logrus.TextFormatter)
-  customFormatter.TimestampFormat = "2006-01-02 15:04:05"
-  logrus.SetFormatter(customFormatter)
-  customFormatter.FullTimestamp = true
+  if err := configureLogging(); err != nil {
+    logrus.Fatal(err)
+  }
+}
+
+// configureLogging - Set the format and destination of the daemon's log.  Lines about a job carry its label, token
+//  and group as fields, which the JSON format keeps separate for indexing
+func configureLogging() (error) {
+
+  switch conf.Attr.LogFormat {
+  case "json":
+    logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+  case "text", "":
+    // Output with absolute time
+    customFormatter := new(logrus.TextFormatter)
+    customFormatter.TimestampFormat = "2006-01-02 15:04:05"
+    customFormatter.FullTimestamp = true
+    logrus.SetFormatter(customFormatter)
+  default:
+    return errors.New("Log format must be text or json: " + conf.Attr.LogFormat)
+  }
+
+  switch conf.Attr.LogOutput {
+  case "stderr", "":
+    logrus.SetOutput(os.Stderr)
+  case "syslog":
+    syslogWriter, err := syslog.New(syslog.LOG_INFO | syslog.LOG_DAEMON, "omicrond")
+    if err != nil {
+      return errors.New("Cannot log to syslog: " + err.Error())
+    }
+    logrus.SetOutput(syslogWriter)
+  default:
+    logFile, err := os.OpenFile(conf.Attr.LogOutput, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil {
+      return errors.New("Cannot log to " + conf.Attr.LogOutput + ": " + err.Error())
+    }
+    logrus.SetOutput(logFile)
+  }
+
+  if err := job.CheckForwardTargets(conf.Attr.JobLogForward); err != nil {
+    return errors.New("Job log " + err.Error())
+  }
+
+  // Forward the log on top of the usual output
+  if len(conf.Attr.LogForward) > 0 {
+    forwardHook, err := job.NewForwardHook(conf.Attr.LogForward)
+    if err != nil {
+      return err
+    }
+    logrus.AddHook(forwardHook)
+  }
+
+  return nil
+}
+
+// splitTargets - Split a comma separated list of forwarding targets, dropping empty entries
+func splitTargets(targetList string) ([]string) {
+
+  targets := []string{}
+  for _, target := range strings.Split(targetList, ",") {
+    if target = strings.TrimSpace(target); target != "" {
+      targets = append(targets, target)
+    }
+  }
+
+  return targets
 }
 
 func main() {
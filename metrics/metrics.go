@@ -0,0 +1,262 @@
+package metrics
+
+import (
+  "bytes"
+  "io"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// durationBuckets - Upper bounds in seconds of the run duration histogram, from quick checks to day long batches
+var durationBuckets = []float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600, 7200, 14400, 43200, 86400}
+
+// histogram - Cumulative bucket counts of observed values along with their sum and count
+type histogram struct {
+  buckets []uint64               // One per durationBuckets entry.  Values above every bound only count towards count
+  sum     float64
+  count   uint64
+}
+
+// skipKey - Label pair of the skipped runs counter
+type skipKey struct {
+  label string
+  limit string
+}
+
+// registry - Everything the daemon reports about its scheduling and its jobs
+type registry struct {
+  sync          sync.Mutex
+  runsStarted   map[string]uint64
+  runsSucceeded map[string]uint64
+  runsFailed    map[string]uint64
+  runsSkipped   map[skipKey]uint64
+  durations     map[string]*histogram
+  lastSuccess   map[string]time.Time
+  running       int
+  reloads       uint64
+  loopLag       time.Duration
+}
+
+// current - The daemon's metrics.  Clear empties it
+var current = newRegistry()
+
+// newRegistry - Empty metrics
+func newRegistry() (*registry) {
+
+  return &registry{
+    runsStarted: make(map[string]uint64),
+    runsSucceeded: make(map[string]uint64),
+    runsFailed: make(map[string]uint64),
+    runsSkipped: make(map[skipKey]uint64),
+    durations: make(map[string]*histogram),
+    lastSuccess: make(map[string]time.Time)}
+}
+
+// Clear - Forget everything recorded so far
+func Clear() {
+
+  fresh := newRegistry()
+  current.sync.Lock()
+  current.runsStarted, current.runsSucceeded, current.runsFailed = fresh.runsStarted, fresh.runsSucceeded, fresh.runsFailed
+  current.runsSkipped, current.durations, current.lastSuccess = fresh.runsSkipped, fresh.durations, fresh.lastSuccess
+  current.running, current.reloads, current.loopLag = 0, 0, 0
+  current.sync.Unlock()
+}
+
+// RunStarted - Count an attempt of a job starting
+func RunStarted(label string) {
+
+  current.sync.Lock()
+  current.runsStarted[label]++
+  current.sync.Unlock()
+}
+
+// RunFinished - Count an attempt's outcome and observe how long it ran.  Successes also move the job's last success
+//  time forward
+func RunFinished(label string, succeeded bool, startTime time.Time, endTime time.Time) {
+
+  current.sync.Lock()
+  defer current.sync.Unlock()
+  if succeeded {
+    current.runsSucceeded[label]++
+    if endTime.After(current.lastSuccess[label]) {
+      current.lastSuccess[label] = endTime
+    }
+  } else {
+    current.runsFailed[label]++
+  }
+
+  if startTime.IsZero() || endTime.Before(startTime) {
+    return
+  }
+  durationHistogram, exists := current.durations[label]
+  if exists == false {
+    durationHistogram = &histogram{buckets: make([]uint64, len(durationBuckets))}
+    current.durations[label] = durationHistogram
+  }
+  seconds := endTime.Sub(startTime).Seconds()
+  for bucketIndex, upperBound := range durationBuckets {
+    if seconds <= upperBound {
+      durationHistogram.buckets[bucketIndex]++
+    }
+  }
+  durationHistogram.sum += seconds
+  durationHistogram.count++
+}
+
+// RunSkipped - Count a run that was skipped because the job, its group or the daemon was at its concurrency limit
+func RunSkipped(label string, limit string) {
+
+  current.sync.Lock()
+  current.runsSkipped[skipKey{label: label, limit: limit}]++
+  current.sync.Unlock()
+}
+
+// SetLastSuccess - Seed a job's last success, such as from the run history when the daemon starts
+func SetLastSuccess(label string, endTime time.Time) {
+
+  current.sync.Lock()
+  if endTime.After(current.lastSuccess[label]) {
+    current.lastSuccess[label] = endTime
+  }
+  current.sync.Unlock()
+}
+
+// SetRunning - Record how many runs are in the tracker, including those waiting to retry
+func SetRunning(running int) {
+
+  current.sync.Lock()
+  current.running = running
+  current.sync.Unlock()
+}
+
+// ScheduleReloaded - Count a replacement of the running schedule
+func ScheduleReloaded() {
+
+  current.sync.Lock()
+  current.reloads++
+  current.sync.Unlock()
+}
+
+// SetLoopLag - Record how far behind its tick the scheduling loop got to evaluating jobs
+func SetLoopLag(lag time.Duration) {
+
+  current.sync.Lock()
+  current.loopLag = lag
+  current.sync.Unlock()
+}
+
+// WriteText - Write every metric in the Prometheus text exposition format
+func WriteText(w io.Writer) (error) {
+
+  current.sync.Lock()
+  var out bytes.Buffer
+
+  writeHeader(&out, "omicrond_runs_started_total", "counter", "Attempts of each job started, retries included.")
+  for _, label := range sortedKeys(current.runsStarted) {
+    writeSample(&out, "omicrond_runs_started_total", labelPairs("label", label), float64(current.runsStarted[label]))
+  }
+  writeHeader(&out, "omicrond_runs_succeeded_total", "counter", "Attempts of each job that exited successfully.")
+  for _, label := range sortedKeys(current.runsSucceeded) {
+    writeSample(&out, "omicrond_runs_succeeded_total", labelPairs("label", label), float64(current.runsSucceeded[label]))
+  }
+  writeHeader(&out, "omicrond_runs_failed_total", "counter", "Attempts of each job that failed, were killed or timed out.")
+  for _, label := range sortedKeys(current.runsFailed) {
+    writeSample(&out, "omicrond_runs_failed_total", labelPairs("label", label), float64(current.runsFailed[label]))
+  }
+
+  writeHeader(&out, "omicrond_runs_skipped_total", "counter", "Runs skipped because a job, group or daemon concurrency limit was reached.")
+  skipKeys := make([]skipKey, 0, len(current.runsSkipped))
+  for key, _ := range current.runsSkipped {
+    skipKeys = append(skipKeys, key)
+  }
+  sort.Slice(skipKeys, func(i, j int) bool {
+    return skipKeys[i].label < skipKeys[j].label || (skipKeys[i].label == skipKeys[j].label && skipKeys[i].limit < skipKeys[j].limit)
+  })
+  for _, key := range skipKeys {
+    writeSample(&out, "omicrond_runs_skipped_total", labelPairs("label", key.label, "limit", key.limit), float64(current.runsSkipped[key]))
+  }
+
+  writeHeader(&out, "omicrond_run_duration_seconds", "histogram", "How long each attempt of a job ran.")
+  durationLabels := make([]string, 0, len(current.durations))
+  for label, _ := range current.durations {
+    durationLabels = append(durationLabels, label)
+  }
+  sort.Strings(durationLabels)
+  for _, label := range durationLabels {
+    durationHistogram := current.durations[label]
+    for bucketIndex, upperBound := range durationBuckets {
+      writeSample(&out, "omicrond_run_duration_seconds_bucket", labelPairs("label", label, "le", formatFloat(upperBound)), float64(durationHistogram.buckets[bucketIndex]))
+    }
+    writeSample(&out, "omicrond_run_duration_seconds_bucket", labelPairs("label", label, "le", "+Inf"), float64(durationHistogram.count))
+    writeSample(&out, "omicrond_run_duration_seconds_sum", labelPairs("label", label), durationHistogram.sum)
+    writeSample(&out, "omicrond_run_duration_seconds_count", labelPairs("label", label), float64(durationHistogram.count))
+  }
+
+  writeHeader(&out, "omicrond_last_success_timestamp_seconds", "gauge", "Unix time each job last finished successfully.")
+  successLabels := make([]string, 0, len(current.lastSuccess))
+  for label, _ := range current.lastSuccess {
+    successLabels = append(successLabels, label)
+  }
+  sort.Strings(successLabels)
+  for _, label := range successLabels {
+    writeSample(&out, "omicrond_last_success_timestamp_seconds", labelPairs("label", label), float64(current.lastSuccess[label].UnixNano()) / 1e9)
+  }
+
+  writeHeader(&out, "omicrond_running_jobs", "gauge", "Runs currently tracked, including those waiting to retry.")
+  writeSample(&out, "omicrond_running_jobs", "", float64(current.running))
+  writeHeader(&out, "omicrond_schedule_reloads_total", "counter", "Times the running schedule was replaced.")
+  writeSample(&out, "omicrond_schedule_reloads_total", "", float64(current.reloads))
+  writeHeader(&out, "omicrond_scheduling_loop_lag_seconds", "gauge", "How long after its tick the scheduling loop last evaluated jobs.")
+  writeSample(&out, "omicrond_scheduling_loop_lag_seconds", "", current.loopLag.Seconds())
+  current.sync.Unlock()
+
+  _, err := w.Write(out.Bytes())
+
+  return err
+}
+
+// writeHeader - Write the HELP and TYPE lines of a metric
+func writeHeader(out *bytes.Buffer, name string, metricType string, help string) {
+
+  out.WriteString("# HELP " + name + " " + help + "\n")
+  out.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// writeSample - Write one sample line.  labels is already formatted by labelPairs
+func writeSample(out *bytes.Buffer, name string, labels string, value float64) {
+
+  out.WriteString(name + labels + " " + formatFloat(value) + "\n")
+}
+
+// labelPairs - Format name and value pairs as '{name="value",...}', escaping the values
+func labelPairs(pairs ...string) (string) {
+
+  escaper := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+  formatted := make([]string, 0, len(pairs) / 2)
+  for pairIndex := 0; pairIndex + 1 < len(pairs); pairIndex += 2 {
+    formatted = append(formatted, pairs[pairIndex] + "=\"" + escaper.Replace(pairs[pairIndex + 1]) + "\"")
+  }
+
+  return "{" + strings.Join(formatted, ",") + "}"
+}
+
+// formatFloat - Shortest representation of a sample value
+func formatFloat(value float64) (string) {
+  return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys - Labels of a counter in order, so the output is stable between scrapes
+func sortedKeys(counter map[string]uint64) ([]string) {
+
+  keys := make([]string, 0, len(counter))
+  for key, _ := range counter {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+
+  return keys
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+  "testing"
+  "bytes"
+  "time"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteText(t *testing.T) {
+
+  Convey("Counters should be kept per label", t, func() {
+    Clear()
+    RunStarted("Backup")
+    RunStarted("Backup")
+    RunStarted("Report")
+    RunSkipped("Backup", "job")
+    var out bytes.Buffer
+    So(WriteText(&out), ShouldEqual, nil)
+    So(out.String(), ShouldContainSubstring, "# TYPE omicrond_runs_started_total counter\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_runs_started_total{label=\"Backup\"} 2\nomicrond_runs_started_total{label=\"Report\"} 1\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_runs_skipped_total{label=\"Backup\",limit=\"job\"} 1\n")
+  })
+
+  Convey("Durations should fall into every bucket they fit under", t, func() {
+    Clear()
+    startTime := time.Unix(1700000000, 0)
+    RunFinished("Backup", true, startTime, startTime.Add(45 * time.Second))
+    RunFinished("Backup", false, startTime, startTime.Add(2 * time.Second))
+    var out bytes.Buffer
+    WriteText(&out)
+    So(out.String(), ShouldContainSubstring, "omicrond_run_duration_seconds_bucket{label=\"Backup\",le=\"1\"} 0\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_run_duration_seconds_bucket{label=\"Backup\",le=\"5\"} 1\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_run_duration_seconds_bucket{label=\"Backup\",le=\"60\"} 2\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_run_duration_seconds_bucket{label=\"Backup\",le=\"+Inf\"} 2\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_run_duration_seconds_sum{label=\"Backup\"} 47\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_runs_succeeded_total{label=\"Backup\"} 1\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_runs_failed_total{label=\"Backup\"} 1\n")
+  })
+
+  Convey("The last success should only move forward", t, func() {
+    Clear()
+    SetLastSuccess("Backup", time.Unix(1700000000, 0))
+    RunFinished("Backup", true, time.Time{}, time.Unix(1600000000, 0))
+    var out bytes.Buffer
+    WriteText(&out)
+    So(out.String(), ShouldContainSubstring, "omicrond_last_success_timestamp_seconds{label=\"Backup\"} 1.7e+09\n")
+  })
+
+  Convey("Daemon gauges and label values should be written out", t, func() {
+    Clear()
+    SetRunning(3)
+    ScheduleReloaded()
+    SetLoopLag(1500 * time.Millisecond)
+    RunStarted("Say \"hi\"")
+    var out bytes.Buffer
+    WriteText(&out)
+    So(out.String(), ShouldContainSubstring, "omicrond_running_jobs 3\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_schedule_reloads_total 1\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_scheduling_loop_lag_seconds 1.5\n")
+    So(out.String(), ShouldContainSubstring, "omicrond_runs_started_total{label=\"Say \\\"hi\\\"\"} 1\n")
+  })
+}
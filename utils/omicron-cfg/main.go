@@ -16,16 +16,20 @@ import (
 var logLevelPtr = flag.Int("v", 2, "Set the debug level: 1 = Panic, 2 = Fatal, 3 = Error, 4 = Warn, 5 = Info, 6 = Debug")
 var configFilePtr = flag.String("config", "", "Path to the legacy configuration file")
 var convertedConfigFilePtr = flag.String("outfile", "", "Path to the new configuration file")
+var runAsUserPtr = flag.String("user", "", "User the converted jobs run as, for converting a per-user crontab")
 var configFile string
+var runAsUser string
 var fileOut *os.File
 
-func init() {
+// parseArguments - Read the command line arguments.  Kept out of init so tests can set the paths themselves
+func parseArguments() {
 
   // Retrieve command line arguments
   flag.Parse()
 
   // Set the path to the daemon config file
   configFile = *configFilePtr
+  runAsUser = *runAsUserPtr
 
   if *convertedConfigFilePtr == "" {
     // Set the default file to be stdout
@@ -59,6 +63,13 @@ func init() {
 
 func main() {
 
+  parseArguments()
+  convertLegacyConfig()
+}
+
+// convertLegacyConfig - Convert configFile into a TOML job config written to fileOut
+func convertLegacyConfig() {
+
   // Make sure that the passed file exists
   if _, err := os.Stat(configFile); os.IsNotExist(err) {
     logrus.Fatal("File does not exists: " + configFile)
@@ -81,7 +92,7 @@ func main() {
 func writeNewConfigFile(jobHandler job.JobSchedule, writer *os.File) {
 
   if err := toml.NewEncoder(writer).Encode(jobHandler); err != nil {
-    logrus.Fatal("Error encoding TOML: %s", err)
+    logrus.Fatalf("Error encoding TOML: %s", err)
   }
 
   return
@@ -104,19 +115,55 @@ func readConfigFile(configFile string) (job.JobSchedule) {
   title := "title"
   titleCounter := 1
 
+  // A job is either five schedule fields or an '@' shorthand (ex '@daily'), then the command.  Fields may be
+  //  separated by spaces or tabs and the command is kept exactly as written
+  jobRegex := regexp.MustCompile("^\\s*(@[A-Za-z]+|([" + regexp.QuoteMeta("*") + "0-9][^\\s]*\\s+)([^\\s]+\\s+){3}[^\\s]+)\\s+(.*)$")
+
+  // 'NAME=value' lines set the environment of every job after them, like in a crontab.  SHELL also picks the shell
+  //  and MAILTO who is mailed the output
+  env := make(map[string]string)
+  shell := "/bin/sh"
+  var mailTo []string
+
   // Read in file line by line and build JobConfig objects
   for scanner.Scan() {
     line := scanner.Text()
-    if isJob, _ := regexp.MatchString("^[" + regexp.QuoteMeta("*") + "0-9]", line); isJob == true {
+    if name, value, isEnv := job.ParseEnvLine(line); isEnv == true {
+      env[name] = value
+      if name == "SHELL" {
+        shell = value
+      } else if name == "MAILTO" {
+        mailTo = nil
+        for _, address := range strings.Split(value, ",") {
+          if strings.TrimSpace(address) != "" {
+            mailTo = append(mailTo, strings.TrimSpace(address))
+          }
+        }
+      }
+      continue
+    }
+    if isJob, _ := regexp.MatchString("^\\s*[@" + regexp.QuoteMeta("*") + "0-9]", line); isJob == true {
       // Found a job, build JobConfig
       var jobObj job.JobConfig
       jobObj.Label = title + strconv.Itoa(titleCounter)
-      lineElements := strings.Split(line, " ")
-      if (len(lineElements) > 4) {
-        jobObj.Schedule = strings.Join(lineElements[:5], " ")
-        jobObj.Command = strings.Join(lineElements[5:], " ")
+      jobObj.ScheduleMode = job.SCHEDULECRON
+      jobObj.Shell = shell + " -c"
+      if len(env) > 0 {
+        jobObj.Env = make(map[string]string)
+        for name, value := range env {
+          jobObj.Env[name] = value
+        }
+      }
+      jobObj.User = runAsUser
+      if len(mailTo) > 0 {
+        jobObj.Notify = []job.NotifyRule{{On: []string{job.NOTIFYOUTPUT}, Type: job.NOTIFYSMTP, To: mailTo}}
+      }
+      matches := jobRegex.FindStringSubmatch(line)
+      if matches != nil {
+        jobObj.Schedule = strings.Join(strings.Fields(matches[1]), " ")
+        jobObj.Command = strings.TrimSpace(matches[4])
       } else {
-        logrus.Fatal("Incorrect configuration: [line]")
+        logrus.Fatal("Incorrect configuration: [" + line + "]")
       }
 
       schedule.Job = append(schedule.Job, jobObj)
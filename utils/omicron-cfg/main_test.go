@@ -16,7 +16,7 @@ func TestConvertLegacyConfig(t *testing.T) {
   fileOutString := "unit_test/example_crontab_converted.toml"
   fileOut, err = os.Create(fileOutString)
 
-  main()
+  convertLegacyConfig()
 
   Convey("Legacy config should have been read and the converted config written without error", t, func() {
     So(err, ShouldEqual, nil)
@@ -28,6 +28,20 @@ func TestConvertLegacyConfig(t *testing.T) {
     So(err, ShouldEqual, nil)
   })
 
+  Convey("Environment lines should be carried into the converted jobs", t, func() {
+    So(len(schedule.Job), ShouldBeGreaterThan, 0)
+    So(schedule.Job[0].Env["PATH"], ShouldEqual, "/usr/local/bin:/usr/bin:/bin")
+    So(schedule.Job[0].Shell, ShouldEqual, "/bin/bash -c")
+  })
+
+  Convey("MAILTO should mail the output of the jobs after it", t, func() {
+    So(len(schedule.Job[0].Notify), ShouldEqual, 0)
+    lastJob := schedule.Job[len(schedule.Job) - 1]
+    So(len(lastJob.Notify), ShouldEqual, 1)
+    So(lastJob.Notify[0].Type, ShouldEqual, job.NOTIFYSMTP)
+    So(lastJob.Notify[0].To, ShouldResemble, []string{"ops@example.com"})
+  })
+
   // Cleanup test
   err = os.Remove(fileOutString)
 }